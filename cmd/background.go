@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+
+	"github.com/3timeslazy/nix-search-tv/indexer"
+
+	"github.com/urfave/cli/v3"
+)
+
+// splitStale splits the indexes that need indexing into the ones
+// that must be indexed before their keys can be printed, and the ones
+// that have usable, but outdated keys and can be updated in background.
+//
+// Options files are always blocking, because once the path
+// changes, the indexed options are of a different file. The same
+// goes for indexes which channel has changed, or which were
+// built with an older format that can't be read anymore
+func splitStale(needIndexing []indexer.Index) (blocking, stale []indexer.Index) {
+	for _, index := range needIndexing {
+		_, optionsFile := index.Fetcher.(indexer.OptionFileFetcher)
+		if optionsFile || index.Metadata.CurrRelease == "" ||
+			indexer.ChannelChanged(index) || indexer.Outdated(index) {
+			blocking = append(blocking, index)
+			continue
+		}
+
+		stale = append(stale, index)
+	}
+
+	return blocking, stale
+}
+
+// startBackgroundUpdate runs `nix-search-tv update` for the indexes in
+// a detached process, so that it keeps running after the current one exits.
+// The results are picked up by the next run.
+//
+// It is a variable, so that tests do not spawn processes
+var startBackgroundUpdate = func(cmd *cli.Command, indexes []indexer.Index) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable: %w", err)
+	}
+
+	args := []string{"update"}
+	if cmd.IsSet(ConfigFlag) {
+		args = append(args, "--"+ConfigFlag, cmd.String(ConfigFlag))
+	}
+	if cmd.IsSet(CacheDirFlag) {
+		args = append(args, "--"+CacheDirFlag, cmd.String(CacheDirFlag))
+	}
+	for _, index := range indexes {
+		args = append(args, "--"+IndexesFlag, index.Name)
+	}
+
+	proc := exec.Command(exe, args...)
+	proc.SysProcAttr = detachedProcAttr()
+	// Nobody is going to read the output. Failures
+	// are recorded in the metadata and shown by `status`
+	proc.Stdin, proc.Stdout, proc.Stderr = nil, nil, nil
+
+	if err := proc.Start(); err != nil {
+		return fmt.Errorf("start update: %w", err)
+	}
+
+	return proc.Process.Release()
+}
+
+// refreshInBackground starts a background update of the stale indexes,
+// unless they are already being updated
+func refreshInBackground(cmd *cli.Command, cacheDir string, stale []indexer.Index) {
+	running, _ := indexer.ReadProgress(cacheDir)
+	stale = slices.DeleteFunc(stale, func(index indexer.Index) bool {
+		return slices.ContainsFunc(running, func(prog indexer.Progress) bool {
+			return prog.Index == index.Name
+		})
+	})
+	if len(stale) == 0 {
+		return
+	}
+
+	if err := startBackgroundUpdate(cmd, stale); err != nil {
+		fmt.Fprintf(Stderr, "background update: %s\n", err)
+	}
+}
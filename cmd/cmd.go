@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/3timeslazy/nix-search-tv/config"
+	"github.com/3timeslazy/nix-search-tv/indexer"
 	"github.com/3timeslazy/nix-search-tv/indexes/indices"
 
 	"github.com/urfave/cli/v3"
@@ -46,6 +47,8 @@ const (
 
 var Stdout io.ReadWriter = os.Stdout
 
+var Stderr io.Writer = os.Stderr
+
 func GetConfig(cmd *cli.Command) (config.Config, error) {
 	var conf config.Config
 	var err error
@@ -67,6 +70,10 @@ func GetConfig(cmd *cli.Command) (config.Config, error) {
 		return config.Config{}, err
 	}
 
+	if err = indexer.ValidateStore(conf.Store); err != nil {
+		return config.Config{}, err
+	}
+
 	if err := os.MkdirAll(conf.CacheDir, 0755); err != nil {
 		return conf, fmt.Errorf("cannot create cache directory: %w", err)
 	}
@@ -81,13 +88,35 @@ func validateIndexes(conf config.Config, indexNames []string) error {
 		}
 	}
 
+	for index := range conf.Experimental.JSONIndexes {
+		if indices.BuiltinIndexes[index] {
+			return fmt.Errorf("json index %[1]q conflicts with builtin %[1]q", index)
+		}
+		if strings.Contains(index, "/") {
+			return fmt.Errorf("json index %q must not contain slashes", index)
+		}
+	}
+
+	for index := range conf.ChannelIndexes {
+		if indices.BuiltinIndexes[index] {
+			return fmt.Errorf("channel index %[1]q conflicts with builtin %[1]q", index)
+		}
+		// The index name is a prefix of the printed packages
+		// and is cut by the first slash in previews
+		if strings.Contains(index, "/") {
+			return fmt.Errorf("channel index %q must not contain slashes", index)
+		}
+	}
+
 	for _, index := range indexNames {
 		if indices.BuiltinIndexes[index] {
 			continue
 		}
 
 		_, parseHTML := conf.Experimental.RenderDocsIndexes[index]
-		if !parseHTML {
+		_, channelIndex := conf.ChannelIndexes[index]
+		_, jsonIndex := conf.Experimental.JSONIndexes[index]
+		if !parseHTML && !channelIndex && !jsonIndex {
 			valid := strings.Join(indexNames, "\n")
 			return fmt.Errorf("unknown index %q. Valid values are:\n %s", index, valid)
 		}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package cmd
+
+import "syscall"
+
+// detachedProcAttr starts the process in its own session, so
+// that it is not killed together with the terminal or tv
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
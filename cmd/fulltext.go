@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/3timeslazy/nix-search-tv/indexer"
+
+	"github.com/urfave/cli/v3"
+)
+
+var FullText = &cli.Command{
+	Name:      "fulltext",
+	UsageText: `nix-search-tv fulltext [options] <query>`,
+	Usage:     `Search packages and options by the words of their descriptions, e.g. 'pdf view* "document viewer"'`,
+	Action:    FullTextAction,
+	Flags: append(
+		BaseFlags(),
+		&cli.BoolFlag{
+			Name:  OfflineFlag,
+			Usage: "disable fetching new indexes",
+		},
+	),
+}
+
+func FullTextAction(ctx context.Context, cmd *cli.Command) error {
+	query := indexer.ParseTextQuery(strings.Join(cmd.Args().Slice(), " "))
+	if query.Empty() {
+		return errors.New("search query is required")
+	}
+
+	conf, err := GetConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("get config: %w", err)
+	}
+
+	available, err := SetupIndexes(conf)
+	if err != nil {
+		return fmt.Errorf("register fetchers: %w", err)
+	}
+
+	indexes, err := GetIndexes(conf, requestedIndexes(cmd, conf, available))
+	if err != nil {
+		return fmt.Errorf("get indexes: %w", err)
+	}
+
+	if !cmd.IsSet(OfflineFlag) {
+		err = updateIndexes(ctx, conf.CacheDir, time.Duration(conf.UpdateInterval), indexes)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Print names the same way as `print` does, so
+	// that the output can be fed to `preview`
+	withPrefix := len(indexes) > 1
+	for _, index := range indexes {
+		found, err := indexer.SearchText(conf.CacheDir, index.Name, query)
+		if errors.Is(err, indexer.ErrNotIndexed) {
+			continue
+		}
+		if errors.Is(err, indexer.ErrNoTextIndex) {
+			fmt.Fprintln(Stderr, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", index.Name, err)
+		}
+
+		for _, key := range found {
+			if withPrefix {
+				key = addIndexPrefix(index.Name, key)
+			}
+			fmt.Fprintln(Stdout, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/3timeslazy/nix-search-tv/config"
+	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/indices"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/urfave/cli/v3"
+)
+
+func TestFullText(t *testing.T) {
+	state := setup(t)
+
+	writeXdgConfig(t, state, map[string]any{
+		config.EnableWaitingMessageTag: false,
+		"indexes":                      []string{indices.Nixpkgs, indices.HomeManager},
+	})
+
+	indices.SetFetchers(map[string]indexer.Fetcher{
+		indices.Nixpkgs: &ContentFetcher{pkgs: map[string]string{
+			"zathura": `{"meta": {"description": "Highly customizable and functional PDF viewer"}}`,
+			"ripgrep": `{"meta": {"description": "Utility that combines the usability of The Silver Searcher with the raw speed of grep"}}`,
+		}},
+		indices.HomeManager: &ContentFetcher{pkgs: map[string]string{
+			"programs.zathura.enable": `{"description": "<p>Whether to enable Zathura, a highly customizable and functional document viewer.</p>"}`,
+		}},
+	})
+
+	fullTextCmd(t, "functional", "view*")
+
+	lines := strings.Split(strings.TrimSpace(state.Stdout.String()), "\n")
+	slices.Sort(lines)
+	assert.Equal(t, []string{"home-manager/ programs.zathura.enable", "nixpkgs/ zathura"}, lines)
+}
+
+func fullTextCmd(t *testing.T, args ...string) {
+	cmd := cli.Command{
+		Writer: io.Discard,
+		Flags: append(
+			BaseFlags(),
+			&cli.BoolFlag{Name: OfflineFlag},
+		),
+		Action: FullTextAction,
+	}
+	err := cmd.Run(context.TODO(), append([]string{"fulltext"}, args...))
+	assert.NoError(t, err)
+}
@@ -6,12 +6,16 @@ import (
 	"maps"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/3timeslazy/nix-search-tv/config"
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
 	"github.com/3timeslazy/nix-search-tv/indexes/indices"
+	"github.com/3timeslazy/nix-search-tv/indexes/jsonfile"
 	"github.com/3timeslazy/nix-search-tv/indexes/optionsfile"
 	"github.com/3timeslazy/nix-search-tv/indexes/renderdocs"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
 )
 
 var ErrUnknownIndex = errors.New("unknown index")
@@ -19,6 +23,30 @@ var ErrUnknownIndex = errors.New("unknown index")
 func SetupIndexes(conf config.Config) ([]string, error) {
 	indexNames := slices.Collect(maps.Keys(indices.BuiltinIndexes))
 
+	for index, ch := range conf.Channels {
+		if err := indices.SetChannel(index, ch); err != nil {
+			return nil, fmt.Errorf("set %q channel: %w", index, err)
+		}
+	}
+
+	for index, ci := range conf.ChannelIndexes {
+		if err := indices.RegisterChannel(index, ci.Index, ci.Channel); err != nil {
+			return nil, fmt.Errorf("register channel index %q: %w", index, err)
+		}
+
+		indexNames = append(indexNames, index)
+	}
+
+	if conf.S3Endpoint != "" {
+		indices.SetS3Endpoint(conf.S3Endpoint)
+	}
+
+	for index, mirror := range conf.Mirrors {
+		if err := indices.SetMirror(index, mirror); err != nil {
+			return nil, fmt.Errorf("set %q mirror: %w", index, err)
+		}
+	}
+
 	for index, indexHTML := range conf.Experimental.RenderDocsIndexes {
 		err := indices.Register(
 			index,
@@ -51,10 +79,54 @@ func SetupIndexes(conf config.Config) ([]string, error) {
 		indexNames = append(indexNames, index)
 	}
 
+	for index, ji := range conf.Experimental.JSONIndexes {
+		opts := jsonstream.Options{
+			Pointer:  ji.Pointer,
+			Format:   ji.Format,
+			KeyField: ji.KeyField,
+		}
+		if ji.Source == "" {
+			return nil, fmt.Errorf("json index %q has no source", index)
+		}
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("json index %q: %w", index, err)
+		}
+
+		err := indices.Register(
+			index,
+			jsonfile.NewFetcher(ji.Source, opts),
+			func() indices.Pkg {
+				return &jsonfile.Package{}
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("register json index %q: %w", index, err)
+		}
+
+		indexNames = append(indexNames, index)
+	}
+
+	client, err := httpclient.New(httpclient.Config{
+		ConnectTimeout: time.Duration(conf.HTTP.ConnectTimeout),
+		ReadTimeout:    time.Duration(conf.HTTP.ReadTimeout),
+		Retries:        conf.HTTP.Retries,
+		Proxy:          conf.HTTP.Proxy,
+		CAFiles:        conf.HTTP.CAFiles,
+		UserAgent:      conf.HTTP.UserAgent,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create http client: %w", err)
+	}
+	indices.SetHTTPClient(client)
+
+	if conf.GitHubToken != "" {
+		indices.SetGitHubToken(conf.GitHubToken)
+	}
+
 	return indexNames, nil
 }
 
-func GetIndexes(cacheDir string, indexNames []string) ([]indexer.Index, error) {
+func GetIndexes(conf config.Config, indexNames []string) ([]indexer.Index, error) {
 	indexes := []indexer.Index{}
 	for _, indexName := range indexNames {
 		fetcher, ok := indices.GetFetcher(indexName)
@@ -62,15 +134,18 @@ func GetIndexes(cacheDir string, indexNames []string) ([]indexer.Index, error) {
 			return nil, fmt.Errorf("%w: %s", ErrUnknownIndex, indexName)
 		}
 
-		md, err := indexer.GetIndexMetadata(cacheDir, indexName)
+		md, err := indexer.GetIndexMetadata(conf.CacheDir, indexName)
 		if err != nil {
 			return nil, fmt.Errorf("get metadata for %q: %w", indexName, err)
 		}
 
 		indexes = append(indexes, indexer.Index{
-			Name:     indexName,
-			Fetcher:  fetcher,
-			Metadata: md,
+			Name:            indexName,
+			Fetcher:         fetcher,
+			Metadata:        md,
+			Store:           conf.Store,
+			Lenient:         slices.Contains(conf.LenientIndexes, indexName),
+			MaxArtifactSize: int64(conf.MaxArtifactSizeMB) << 20,
 		})
 	}
 
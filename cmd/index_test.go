@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/3timeslazy/nix-search-tv/config"
+	"github.com/3timeslazy/nix-search-tv/indexes/indices"
+	"github.com/3timeslazy/nix-search-tv/indexes/nixos"
+	"github.com/3timeslazy/nix-search-tv/indexes/nixpkgs"
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestChannelIndexes(t *testing.T) {
+	t.Run("registered next to builtin", func(t *testing.T) {
+		setup(t)
+
+		conf := config.Config{
+			ChannelIndexes: map[string]config.ChannelIndex{
+				"nixpkgs-stable": {Index: indices.Nixpkgs, Channel: "nixos-25.05"},
+				"nixos-24.11":    {Index: indices.NixOS, Channel: "nixos-24.11"},
+			},
+		}
+		assert.NoError(t, validateIndexes(conf, []string{"nixpkgs", "nixpkgs-stable"}))
+
+		available, err := SetupIndexes(conf)
+		assert.NoError(t, err)
+		assert.True(t, slices.Contains(available, "nixpkgs-stable"))
+		assert.True(t, slices.Contains(available, "nixos-24.11"))
+
+		fetcher, ok := indices.GetFetcher("nixpkgs-stable")
+		assert.True(t, ok)
+		assert.Equal(t, "nixos-25.05", fetcher.(*nixpkgs.Fetcher).Channel)
+
+		fetcher, ok = indices.GetFetcher("nixos-24.11")
+		assert.True(t, ok)
+		assert.Equal(t, "nixos-24.11", fetcher.(*nixos.Fetcher).Channel)
+
+		pkg, err := indices.Decode("nixpkgs-stable", json.RawMessage(`{"meta":{"position":"pkgs/a/default.nix:1"}}`))
+		assert.NoError(t, err)
+		assert.Equal(t, "https://github.com/NixOS/nixpkgs/blob/nixos-25.05/pkgs/a/default.nix", pkg.GetSource())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := map[string]config.ChannelIndex{
+			"unknown channel":     {Index: indices.Nixpkgs, Channel: "nixos-stable"},
+			"nixos from nixpkgs":  {Index: indices.NixOS, Channel: "nixpkgs-unstable"},
+			"unsupported builtin": {Index: indices.Nur, Channel: "nixos-25.05"},
+		}
+		for name, ci := range tests {
+			setup(t)
+
+			_, err := SetupIndexes(config.Config{
+				ChannelIndexes: map[string]config.ChannelIndex{"stable": ci},
+			})
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("conflicts with builtin", func(t *testing.T) {
+		conf := config.Config{
+			ChannelIndexes: map[string]config.ChannelIndex{
+				indices.Nixpkgs: {Index: indices.Nixpkgs, Channel: "nixos-25.05"},
+			},
+		}
+		assert.Error(t, validateIndexes(conf, nil))
+	})
+}
@@ -21,6 +21,11 @@ var root = &cli.Command{
 		cmd.Preview,
 		cmd.Source,
 		cmd.Homepage,
+		cmd.Search,
+		cmd.FullText,
+		cmd.Query,
+		cmd.Status,
+		cmd.Update,
 	},
 }
 
@@ -2,10 +2,15 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/3timeslazy/nix-search-tv/config"
+	"github.com/3timeslazy/nix-search-tv/indexer"
 	"github.com/3timeslazy/nix-search-tv/indexes/indices"
 
 	"github.com/alecthomas/assert/v2"
@@ -63,3 +68,49 @@ func previewCmd(t *testing.T, args ...string) {
 	err := cmd.Run(context.TODO(), append([]string{"preview"}, args...))
 	assert.NoError(t, err)
 }
+
+func TestPreviewFileStore(t *testing.T) {
+	state := setup(t)
+
+	writeXdgConfig(t, state, map[string]any{
+		config.EnableWaitingMessageTag: false,
+		"indexes":                      []string{indices.Nixpkgs},
+		"store":                        "file",
+	})
+
+	setNixpkgs("test-pkg")
+
+	printCmd(t)
+	assert.Equal(t, "test-pkg\n", state.Stdout.String())
+
+	state.Stdout.Reset()
+
+	previewCmd(t, "--json", "test-pkg")
+	assert.Equal(t, "{\"_key\":\"test-pkg\",}\n", state.Stdout.String())
+}
+
+func TestPreviewWaitingProgress(t *testing.T) {
+	state := setup(t)
+
+	writeXdgConfig(t, state, map[string]any{
+		"indexes": []string{indices.Nixpkgs},
+	})
+
+	indexDir := filepath.Join(state.CacheDir, "nix-search-tv", indices.Nixpkgs)
+	assert.NoError(t, os.MkdirAll(indexDir, 0755))
+
+	prog, err := json.Marshal(indexer.Progress{
+		Index:     indices.Nixpkgs,
+		Phase:     indexer.PhaseParsing,
+		Bytes:     2048,
+		Packages:  1000,
+		Elapsed:   3 * time.Second,
+		UpdatedAt: time.Now(),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(indexDir, "progress.json"), prog, 0666))
+
+	previewCmd(t, waitingMessage)
+
+	assert.Contains(t, state.Stdout.String(), "nixpkgs: parsing, 2.0KiB, 1000 packages, 3s")
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/3timeslazy/nix-search-tv/config"
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/pkgs/query"
 
 	"github.com/urfave/cli/v3"
 )
@@ -20,10 +21,17 @@ var Print = &cli.Command{
 	UsageText: "nix-search-tv print",
 	Usage:     "Print indexed package names. If there is no indexed packages, they'll get indexed first",
 	Action:    PrintAction,
-	Flags: append(BaseFlags(), &cli.BoolFlag{
-		Name:  OfflineFlag,
-		Usage: "disable fetching new indexes",
-	}),
+	Flags: append(
+		BaseFlags(),
+		&cli.BoolFlag{
+			Name:  OfflineFlag,
+			Usage: "disable fetching new indexes",
+		},
+		&cli.StringFlag{
+			Name:  QueryFlag,
+			Usage: "only print packages matching the query, see the `query` command",
+		},
+	),
 }
 
 func PrintAction(ctx context.Context, cmd *cli.Command) error {
@@ -37,23 +45,20 @@ func PrintAction(ctx context.Context, cmd *cli.Command) error {
 		return fmt.Errorf("register fetchers: %w", err)
 	}
 
-	requested := available
-	if cmd.IsSet(IndexesFlag) {
-		flags := cmd.StringSlice(IndexesFlag)
+	requested := requestedIndexes(cmd, conf, available)
 
-		requested = slices.DeleteFunc(requested, func(index string) bool {
-			return !slices.Contains(flags, index)
-		})
-	} else {
-		requested = slices.DeleteFunc(requested, func(index string) bool {
-			builtin := slices.Contains(conf.Indexes, index)
-			_, renderDocs := conf.Experimental.RenderDocsIndexes[index]
-			_, optionsFile := conf.Experimental.OptionsFile[index]
-			return !builtin && !renderDocs && !optionsFile
-		})
+	q, err := query.Parse(cmd.String(QueryFlag))
+	if err != nil {
+		return fmt.Errorf("parse query: %w", err)
+	}
+	printKeys := func(index string, withPrefix bool) error {
+		if q.Empty() {
+			return PrintIndexKeys(conf, index, withPrefix)
+		}
+		return PrintMatchingKeys(conf, index, withPrefix, q)
 	}
 
-	indexes, err := GetIndexes(conf.CacheDir, requested)
+	indexes, err := GetIndexes(conf, requested)
 	if err != nil {
 		return fmt.Errorf("get indexes: %w", err)
 	}
@@ -71,6 +76,13 @@ func PrintAction(ctx context.Context, cmd *cli.Command) error {
 		needIndexing = nil
 	}
 
+	// Outdated indexes are printed as they are and updated in
+	// background, so that only indexes without keys wait for indexing
+	needIndexing, stale := splitStale(needIndexing)
+	if len(stale) > 0 {
+		refreshInBackground(cmd, conf.CacheDir, stale)
+	}
+
 	if len(needIndexing) > 0 {
 		if conf.EnableWaitingMessage {
 			PrintWaiting(Stdout)
@@ -84,25 +96,23 @@ func PrintAction(ctx context.Context, cmd *cli.Command) error {
 			return need.Name == index.Name
 		})
 		if canPrint {
-			err = PrintIndexKeys(conf, index.Name, withPrefix)
+			err = printKeys(index.Name, withPrefix)
 			if err != nil {
-				return fmt.Errorf("%s: %w", index, err)
+				return fmt.Errorf("%s: %w", index.Name, err)
 			}
 		}
 	}
 
 	results := indexer.RunIndexing(ctx, conf.CacheDir, needIndexing)
 	for result := range results {
+		// Stdout is for package names only, so that
+		// errors are not picked up as packages by fzf and tv
 		if result.Err != nil {
-			msg := addIndexPrefix(
-				result.Index,
-				fmt.Sprintf("indexing failed: %s\n", result.Err),
-			)
-			Stdout.Write([]byte(msg))
+			fmt.Fprintf(Stderr, "%s: indexing failed: %s\n", result.Index, result.Err)
 			continue
 		}
 
-		err := PrintIndexKeys(conf, result.Index, withPrefix)
+		err := printKeys(result.Index, withPrefix)
 		if err != nil {
 			return fmt.Errorf("%s: %w", result.Index, err)
 		}
@@ -111,6 +121,27 @@ func PrintAction(ctx context.Context, cmd *cli.Command) error {
 	return nil
 }
 
+// requestedIndexes filters the available indexes by
+// the --indexes flag or by the config if the flag is not set
+func requestedIndexes(cmd *cli.Command, conf config.Config, available []string) []string {
+	if cmd.IsSet(IndexesFlag) {
+		flags := cmd.StringSlice(IndexesFlag)
+
+		return slices.DeleteFunc(available, func(index string) bool {
+			return !slices.Contains(flags, index)
+		})
+	}
+
+	return slices.DeleteFunc(available, func(index string) bool {
+		builtin := slices.Contains(conf.Indexes, index)
+		_, renderDocs := conf.Experimental.RenderDocsIndexes[index]
+		_, optionsFile := conf.Experimental.OptionsFile[index]
+		_, channelIndex := conf.ChannelIndexes[index]
+		_, jsonIndex := conf.Experimental.JSONIndexes[index]
+		return !builtin && !renderDocs && !optionsFile && !channelIndex && !jsonIndex
+	})
+}
+
 func PrintIndexKeys(conf config.Config, index string, withPrefix bool) error {
 	keys, err := indexer.OpenKeysReader(conf.CacheDir, index)
 	if err != nil {
@@ -18,6 +18,7 @@ import (
 
 	"github.com/3timeslazy/nix-search-tv/config"
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/homemanager"
 	"github.com/3timeslazy/nix-search-tv/indexes/indices"
 
 	"github.com/alecthomas/assert/v2"
@@ -184,8 +185,10 @@ func TestPrintIndexing(t *testing.T) {
 
 		expectedPaths := map[string]bool{
 			"nixpkgs":               false,
-			"nixpkgs/badger":        false,
-			"nixpkgs/cache.txt":     false,
+			"nixpkgs/current":       false,
+			"nixpkgs/generations":   false,
+			"/badger":               false,
+			"/cache.txt":            false,
 			"nixpkgs/metadata.json": false,
 		}
 		err := filepath.WalkDir(state.CacheDir, func(path string, d fs.DirEntry, err error) error {
@@ -259,13 +262,27 @@ func TestPrintIndexing(t *testing.T) {
 
 		expected := []string{
 			waitingMessage,
-			"nixpkgs/ indexing failed: get latest release: failed to get latest release",
 			"home-manager/ programs.zsh",
 			"",
 		}
 		output := state.Stdout.String()
 
 		assert.Equal(t, expected, strings.Split(output, "\n"))
+		assert.Equal(t, "nixpkgs: indexing failed: get latest release: failed to get latest release\n", state.Stderr.String())
+
+		// Failed index is not retried until the backoff passes
+		state.Stdout.Reset()
+		state.Stderr.Reset()
+
+		printCmd(t, "--indexes", indices.Nixpkgs+","+indices.HomeManager)
+
+		assert.Equal(t, []string{"home-manager/ programs.zsh", ""}, strings.Split(state.Stdout.String(), "\n"))
+		assert.Equal(t, "", state.Stderr.String())
+
+		md, err := indexer.GetIndexMetadata(filepath.Join(state.CacheDir, "nix-search-tv"), indices.Nixpkgs)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, md.FailedAttempts)
+		assert.True(t, md.NextRetryAt.After(time.Now()))
 	})
 
 	t.Run("need update, but not new version", func(t *testing.T) {
@@ -561,6 +578,39 @@ func TestParseHTML(t *testing.T) {
 	})
 }
 
+func TestMirror(t *testing.T) {
+	state := setup(t)
+
+	htmlPage := readTestdata(t, "nvf.html")
+	srv := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		wr.Write(htmlPage)
+	}))
+	defer srv.Close()
+
+	writeXdgConfig(t, state, map[string]any{
+		config.EnableWaitingMessageTag: false,
+		"indexes":                      []string{indices.HomeManager},
+		"mirrors": map[string]string{
+			indices.HomeManager: srv.URL + "/options.xhtml",
+		},
+	})
+
+	indices.SetFetchers(map[string]indexer.Fetcher{
+		indices.HomeManager: &homemanager.Fetcher{},
+	})
+
+	printCmd(t)
+
+	expected := []string{
+		"",
+		"_module.args",
+		"vim.enableLuaLoader",
+		"vim.package",
+	}
+	output := strings.Split(state.Stdout.String(), "\n")
+	assertSortEqual(t, expected, output)
+}
+
 func TestOptionsFile(t *testing.T) {
 	pwd, err := os.Getwd()
 	assert.NoError(t, err)
@@ -618,8 +668,9 @@ func TestOptionsFile(t *testing.T) {
 		state.Stdout.Reset()
 
 		setMetadata(t, state, "file", indexer.IndexMetadata{
-			CurrRelease:   optionsPath,
-			LastIndexedAt: time.Time{},
+			CurrRelease:    optionsPath,
+			LastIndexedAt:  time.Time{},
+			StorageVersion: indexer.StorageVersion,
 		})
 
 		printCmd(t)
@@ -672,13 +723,66 @@ func TestOptionsFile(t *testing.T) {
 	})
 }
 
+func TestJSONIndexes(t *testing.T) {
+	state := setup(t)
+
+	ndjson := filepath.Join(t.TempDir(), "tools.ndjson")
+	err := os.WriteFile(ndjson, []byte(`{"id": "ripgrep", "description": "Fast grep"}
+{"id": "fd", "homepage": "https://github.com/sharkdp/fd"}
+`), 0644)
+	assert.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		wr.Write([]byte(`{"data": {"options": [{"name": "services.foo.enable", "type": "boolean"}]}}`))
+	}))
+	defer srv.Close()
+
+	writeXdgConfig(t, state, map[string]any{
+		config.EnableWaitingMessageTag: false,
+		"indexes":                      []string{},
+		"experimental": map[string]any{
+			"json_indexes": map[string]any{
+				"tools": map[string]string{
+					"source":    ndjson,
+					"format":    "ndjson",
+					"key_field": "id",
+				},
+				"opts": map[string]string{
+					"source":    srv.URL,
+					"pointer":   "/data/options",
+					"format":    "array",
+					"key_field": "name",
+				},
+			},
+		},
+	})
+
+	printCmd(t)
+
+	expected := []string{
+		"",
+		"opts/ services.foo.enable",
+		"tools/ fd",
+		"tools/ ripgrep",
+	}
+	output := strings.Split(state.Stdout.String(), "\n")
+	assertSortEqual(t, expected, output)
+
+	indices.Reset()
+	state.Stdout.Reset()
+	previewCmd(t, "tools/ ripgrep")
+	assert.Contains(t, state.Stdout.String(), "Fast grep")
+	assert.Contains(t, state.Stdout.String(), "ripgrep")
+}
+
 func printCmd(t *testing.T, args ...string) {
 	cmd := cli.Command{
 		Writer: io.Discard,
-		Flags: append(BaseFlags(), &cli.BoolFlag{
-			Name:  OfflineFlag,
-			Usage: "disable fetching new indexes",
-		}),
+		Flags: append(
+			BaseFlags(),
+			&cli.BoolFlag{Name: OfflineFlag},
+			&cli.StringFlag{Name: QueryFlag},
+		),
 		Action: PrintAction,
 	}
 	err := cmd.Run(context.TODO(), append([]string{"print"}, args...))
@@ -714,3 +818,49 @@ func readTestdata(t *testing.T, filename string) []byte {
 
 	return data
 }
+
+func TestPrintStaleIndex(t *testing.T) {
+	state := setup(t)
+
+	writeXdgConfig(t, state, map[string]any{
+		config.EnableWaitingMessageTag: true,
+		"indexes":                      []string{indices.Nixpkgs, indices.HomeManager},
+	})
+
+	indices.SetFetchers(map[string]indexer.Fetcher{
+		indices.Nixpkgs:     &PkgsFetcher{[]string{"lazygit"}},
+		indices.HomeManager: &PkgsFetcher{[]string{"programs.lazygit.enable"}},
+	})
+
+	printCmd(t, "--indexes", indices.Nixpkgs)
+	state.Stdout.Reset()
+
+	// nixpkgs is outdated, while home-manager has never been indexed
+	setMetadata(t, state, indices.Nixpkgs, indexer.IndexMetadata{
+		CurrRelease:    "latest",
+		LastIndexedAt:  time.Now().Add(-30 * 24 * time.Hour),
+		StorageVersion: indexer.StorageVersion,
+	})
+	indices.SetFetchers(map[string]indexer.Fetcher{
+		indices.Nixpkgs:     &FailFetcher{},
+		indices.HomeManager: &PkgsFetcher{[]string{"programs.lazygit.enable"}},
+	})
+
+	background := []string{}
+	startBackgroundUpdate = func(_ *cli.Command, indexes []indexer.Index) error {
+		for _, index := range indexes {
+			background = append(background, index.Name)
+		}
+		return nil
+	}
+
+	printCmd(t)
+
+	assert.Equal(t, []string{indices.Nixpkgs}, background)
+	assert.Equal(t, []string{
+		waitingMessage,
+		"nixpkgs/ lazygit",
+		"home-manager/ programs.lazygit.enable",
+		"",
+	}, strings.Split(state.Stdout.String(), "\n"))
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/3timeslazy/nix-search-tv/config"
+	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/indices"
+	"github.com/3timeslazy/nix-search-tv/pkgs/query"
+
+	"github.com/urfave/cli/v3"
+)
+
+const QueryFlag = "query"
+
+var Query = &cli.Command{
+	Name:      "query",
+	UsageText: `nix-search-tv query [options] <query>`,
+	Usage:     "Print packages and options matching a query like 'type:bool name:services.nginx.* !broken'",
+	Action:    QueryAction,
+	Flags: append(
+		BaseFlags(),
+		&cli.BoolFlag{
+			Name:  OfflineFlag,
+			Usage: "disable fetching new indexes",
+		},
+	),
+}
+
+func QueryAction(ctx context.Context, cmd *cli.Command) error {
+	q, err := query.Parse(strings.Join(cmd.Args().Slice(), " "))
+	if err != nil {
+		return fmt.Errorf("parse query: %w", err)
+	}
+	if q.Empty() {
+		return errors.New("query is required")
+	}
+
+	conf, err := GetConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("get config: %w", err)
+	}
+
+	available, err := SetupIndexes(conf)
+	if err != nil {
+		return fmt.Errorf("register fetchers: %w", err)
+	}
+
+	indexes, err := GetIndexes(conf, requestedIndexes(cmd, conf, available))
+	if err != nil {
+		return fmt.Errorf("get indexes: %w", err)
+	}
+
+	if !cmd.IsSet(OfflineFlag) {
+		err = updateIndexes(ctx, conf.CacheDir, time.Duration(conf.UpdateInterval), indexes)
+		if err != nil {
+			return err
+		}
+	}
+
+	withPrefix := len(indexes) > 1
+	for _, index := range indexes {
+		err := PrintMatchingKeys(conf, index.Name, withPrefix, q)
+		if errors.Is(err, indexer.ErrNotIndexed) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", index.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// PrintMatchingKeys prints names of the packages of the index matching the query
+func PrintMatchingKeys(conf config.Config, index string, withPrefix bool, q query.Query) error {
+	return indexer.ScanIndex(conf.CacheDir, index, "", func(key string, content []byte) error {
+		pkg, err := indices.Decode(index, injectKey(key, content))
+		if err != nil {
+			// A single broken package should not break the query
+			return nil
+		}
+		if !q.Match(pkg) {
+			return nil
+		}
+
+		if withPrefix {
+			key = addIndexPrefix(index, key)
+		}
+		_, err = fmt.Fprintln(Stdout, key)
+		return err
+	})
+}
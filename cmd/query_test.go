@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/3timeslazy/nix-search-tv/config"
+	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/indices"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/urfave/cli/v3"
+)
+
+func TestQuery(t *testing.T) {
+	setupQuery := func(t *testing.T) state {
+		state := setup(t)
+
+		writeXdgConfig(t, state, map[string]any{
+			config.EnableWaitingMessageTag: false,
+			"indexes":                      []string{indices.Nixpkgs, indices.NixOS},
+		})
+
+		indices.SetFetchers(map[string]indexer.Fetcher{
+			indices.Nixpkgs: &ContentFetcher{pkgs: map[string]string{
+				"nginx":   `{"meta": {"license": {"spdxId": "BSD-2-Clause"}, "platforms": ["x86_64-linux", "aarch64-darwin"]}}`,
+				"ripgrep": `{"meta": {"license": [{"spdxId": "MIT"}, {"spdxId": "Unlicense"}], "platforms": ["x86_64-linux", "aarch64-darwin"]}}`,
+				"old-rg":  `{"meta": {"license": "MIT", "broken": true, "platforms": ["aarch64-darwin"]}}`,
+			}},
+			indices.NixOS: &ContentFetcher{pkgs: map[string]string{
+				"services.nginx.enable":     `{"type": "boolean"}`,
+				"services.nginx.package":    `{"type": "package"}`,
+				"services.nginx.enableQuic": `{"type": "boolean"}`,
+				"programs.git.enable":       `{"type": "boolean"}`,
+			}},
+		})
+
+		return state
+	}
+
+	t.Run("standalone", func(t *testing.T) {
+		state := setupQuery(t)
+
+		queryCmd(t, "--indexes", indices.NixOS, "type:bool", "name:services.nginx.*")
+
+		assert.Equal(t, "services.nginx.enable\nservices.nginx.enableQuic\n", state.Stdout.String())
+	})
+
+	t.Run("across indexes", func(t *testing.T) {
+		state := setupQuery(t)
+
+		queryCmd(t, "license:mit platform:aarch64-darwin !broken")
+
+		assert.Equal(t, "nixpkgs/ ripgrep\n", state.Stdout.String())
+	})
+
+	t.Run("print", func(t *testing.T) {
+		state := setupQuery(t)
+
+		printCmd(t, "--indexes", indices.Nixpkgs, "--query", "license:mit")
+
+		assert.Equal(t, "old-rg\nripgrep\n", state.Stdout.String())
+	})
+}
+
+func queryCmd(t *testing.T, args ...string) {
+	cmd := cli.Command{
+		Writer: io.Discard,
+		Flags: append(
+			BaseFlags(),
+			&cli.BoolFlag{Name: OfflineFlag},
+		),
+		Action: QueryAction,
+	}
+	err := cmd.Run(context.TODO(), append([]string{"query"}, args...))
+	assert.NoError(t, err)
+}
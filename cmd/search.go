@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/indices"
+	"github.com/3timeslazy/nix-search-tv/pkgs/fuzzy"
+
+	"github.com/urfave/cli/v3"
+)
+
+const LimitFlag = "limit"
+
+var Search = &cli.Command{
+	Name:      "search",
+	UsageText: "nix-search-tv search [options] <query>",
+	Usage:     "Search packages by name, description and main program without a fuzzy finder",
+	Action:    SearchAction,
+	Flags: append(
+		BaseFlags(),
+		&cli.IntFlag{
+			Name:  LimitFlag,
+			Value: 20,
+			Usage: "maximum number of results",
+		},
+		&cli.BoolFlag{
+			Name:  JsonFlag,
+			Usage: "output results as JSON",
+		},
+		&cli.BoolFlag{
+			Name:  OfflineFlag,
+			Usage: "disable fetching new indexes",
+		},
+	),
+}
+
+type SearchResult struct {
+	Index       string `json:"index"`
+	Key         string `json:"key"`
+	Description string `json:"description"`
+	MainProgram string `json:"main_program,omitempty"`
+	Score       int    `json:"score"`
+}
+
+func SearchAction(ctx context.Context, cmd *cli.Command) error {
+	query := strings.Fields(strings.Join(cmd.Args().Slice(), " "))
+	if len(query) == 0 {
+		return errors.New("search query is required")
+	}
+
+	conf, err := GetConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("get config: %w", err)
+	}
+
+	available, err := SetupIndexes(conf)
+	if err != nil {
+		return fmt.Errorf("register fetchers: %w", err)
+	}
+
+	indexes, err := GetIndexes(conf, requestedIndexes(cmd, conf, available))
+	if err != nil {
+		return fmt.Errorf("get indexes: %w", err)
+	}
+
+	if !cmd.IsSet(OfflineFlag) {
+		err = updateIndexes(ctx, conf.CacheDir, time.Duration(conf.UpdateInterval), indexes)
+		if err != nil {
+			return err
+		}
+	}
+
+	results := []SearchResult{}
+	for _, index := range indexes {
+		err := indexer.ScanIndex(conf.CacheDir, index.Name, "", func(key string, content []byte) error {
+			pkg, err := indices.Decode(index.Name, injectKey(key, content))
+			if err != nil {
+				// A single broken package should not break the search
+				return nil
+			}
+
+			res := SearchResult{
+				Index:       index.Name,
+				Key:         key,
+				Description: pkg.GetDescription(),
+			}
+			if prog, ok := pkg.(indices.MainProgramPkg); ok {
+				res.MainProgram = prog.GetMainProgram()
+			}
+
+			score, ok := rankPackage(query, res)
+			if ok {
+				res.Score = score
+				results = append(results, res)
+			}
+
+			return nil
+		})
+		if errors.Is(err, indexer.ErrNotIndexed) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", index.Name, err)
+		}
+	}
+
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		return cmp.Or(
+			cmp.Compare(b.Score, a.Score),
+			cmp.Compare(a.Key, b.Key),
+		)
+	})
+	if limit := int(cmd.Int(LimitFlag)); limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	if cmd.IsSet(JsonFlag) {
+		enc := json.NewEncoder(Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	withPrefix := len(indexes) > 1
+	for _, res := range results {
+		name := res.Key
+		if withPrefix {
+			name = addIndexPrefix(res.Index, res.Key)
+		}
+		fmt.Fprintf(Stdout, "%s\t%s\n", name, res.Description)
+	}
+
+	return nil
+}
+
+// Matches in the package name or its main program are
+// worth more than in the description
+const (
+	descriptionMatchScore = 100
+	nameMatchWeight       = 2
+)
+
+// rankPackage scores how well the package matches the query. Every
+// word of the query must match either the name, the main program,
+// or the description
+func rankPackage(query []string, res SearchResult) (int, bool) {
+	// Also match the last attribute, so that `gitpython` ranks
+	// `python3Packages.gitpython` as high as `gitpython`
+	attr := res.Key[strings.LastIndex(res.Key, ".")+1:]
+	desc := strings.ToLower(res.Description)
+
+	total := 0
+	for _, word := range query {
+		best, matched := 0, false
+
+		for _, text := range []string{res.Key, attr, res.MainProgram} {
+			if text == "" {
+				continue
+			}
+			if score, ok := fuzzy.Score(word, text); ok {
+				best = max(best, score*nameMatchWeight)
+				matched = true
+			}
+		}
+
+		if strings.Contains(desc, strings.ToLower(word)) {
+			best = max(best, descriptionMatchScore)
+			matched = true
+		}
+
+		if !matched {
+			return 0, false
+		}
+		total += best
+	}
+
+	return total, true
+}
+
+// updateIndexes indexes the indexes that need it and
+// reports failures into stderr
+func updateIndexes(
+	ctx context.Context,
+	cacheDir string,
+	updateInterval time.Duration,
+	indexes []indexer.Index,
+) error {
+	needIndexing, err := indexer.NeedIndexing(cacheDir, updateInterval, indexes)
+	if err != nil {
+		return fmt.Errorf("check if indexing needed: %w", err)
+	}
+
+	results := indexer.RunIndexing(ctx, cacheDir, needIndexing)
+	for result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(Stderr, "%s: indexing failed: %s\n", result.Index, result.Err)
+		}
+	}
+
+	return nil
+}
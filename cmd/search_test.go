@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/3timeslazy/nix-search-tv/config"
+	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/indices"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/urfave/cli/v3"
+)
+
+func TestSearch(t *testing.T) {
+	setupSearch := func(t *testing.T) state {
+		state := setup(t)
+
+		writeXdgConfig(t, state, map[string]any{
+			config.EnableWaitingMessageTag: false,
+			"indexes":                      []string{indices.Nixpkgs, indices.HomeManager},
+		})
+
+		indices.SetFetchers(map[string]indexer.Fetcher{
+			indices.Nixpkgs: &ContentFetcher{pkgs: map[string]string{
+				"ripgrep":     `{"meta": {"description": "Utility that combines the usability of The Silver Searcher with the raw speed of grep", "mainProgram": "rg"}}`,
+				"gnugrep":     `{"meta": {"description": "GNU implementation of the Unix grep command", "mainProgram": "grep"}}`,
+				"zathura":     `{"meta": {"description": "Highly customizable and functional PDF viewer", "mainProgram": "zathura"}}`,
+				"fd":          `{"meta": {"description": "Simple, fast and user-friendly alternative to find", "mainProgram": "fd"}}`,
+				"ripgrep-all": `{"meta": {"description": "Ripgrep, but also search in PDFs, E-Books, Office documents, zip, tar.gz, and more", "mainProgram": "rga"}}`,
+			}},
+			indices.HomeManager: &ContentFetcher{pkgs: map[string]string{
+				"programs.ripgrep.enable": `{"description": "<p>Whether to enable Ripgrep.</p>"}`,
+			}},
+		})
+
+		return state
+	}
+
+	t.Run("ranked by name first", func(t *testing.T) {
+		state := setupSearch(t)
+
+		searchCmd(t, "--indexes", indices.Nixpkgs, "ripgrep")
+
+		lines := strings.Split(strings.TrimSpace(state.Stdout.String()), "\n")
+		assert.Equal(t, 2, len(lines))
+		assert.True(t, strings.HasPrefix(lines[0], "ripgrep\t"))
+		assert.True(t, strings.HasPrefix(lines[1], "ripgrep-all\t"))
+	})
+
+	t.Run("main program", func(t *testing.T) {
+		state := setupSearch(t)
+
+		searchCmd(t, "--indexes", indices.Nixpkgs, "--limit", "1", "rg")
+
+		assert.True(t, strings.HasPrefix(state.Stdout.String(), "ripgrep\t"))
+	})
+
+	t.Run("description", func(t *testing.T) {
+		state := setupSearch(t)
+
+		searchCmd(t, "--indexes", indices.Nixpkgs, "pdf", "viewer")
+
+		assert.Equal(t, "zathura\tHighly customizable and functional PDF viewer\n", state.Stdout.String())
+	})
+
+	t.Run("json across indexes", func(t *testing.T) {
+		state := setupSearch(t)
+
+		searchCmd(t, "--json", "--limit", "2", "ripgrep")
+
+		results := []SearchResult{}
+		assert.NoError(t, json.Unmarshal(state.Stdout.Bytes(), &results))
+		assert.Equal(t, 2, len(results))
+		assert.Equal(t, "ripgrep", results[0].Key)
+		assert.Equal(t, indices.Nixpkgs, results[0].Index)
+		assert.Equal(t, "rg", results[0].MainProgram)
+	})
+
+	t.Run("no matches", func(t *testing.T) {
+		state := setupSearch(t)
+
+		searchCmd(t, "--indexes", indices.HomeManager, "zathura")
+
+		assert.Equal(t, "", state.Stdout.String())
+	})
+}
+
+func searchCmd(t *testing.T, args ...string) {
+	cmd := cli.Command{
+		Writer: io.Discard,
+		Flags: append(
+			BaseFlags(),
+			&cli.IntFlag{Name: LimitFlag, Value: 20},
+			&cli.BoolFlag{Name: JsonFlag},
+			&cli.BoolFlag{Name: OfflineFlag},
+		),
+		Action: SearchAction,
+	}
+	err := cmd.Run(context.TODO(), append([]string{"search"}, args...))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/3timeslazy/nix-search-tv/indexer"
+
+	"github.com/urfave/cli/v3"
+)
+
+var Status = &cli.Command{
+	Name:      "status",
+	UsageText: "nix-search-tv status [options]",
+	Usage:     "Show when the indexes were updated, how big they are and why they failed",
+	Action:    StatusAction,
+	Flags: append(
+		BaseFlags(),
+		&cli.BoolFlag{
+			Name:  JsonFlag,
+			Usage: "output status as JSON",
+		},
+	),
+}
+
+type IndexStatus struct {
+	Index string `json:"index"`
+	indexer.IndexMetadata
+}
+
+func StatusAction(ctx context.Context, cmd *cli.Command) error {
+	conf, err := GetConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("get config: %w", err)
+	}
+
+	available, err := SetupIndexes(conf)
+	if err != nil {
+		return fmt.Errorf("register fetchers: %w", err)
+	}
+
+	indexes, err := GetIndexes(conf, requestedIndexes(cmd, conf, available))
+	if err != nil {
+		return fmt.Errorf("get indexes: %w", err)
+	}
+
+	statuses := []IndexStatus{}
+	for _, index := range indexes {
+		statuses = append(statuses, IndexStatus{
+			Index:         index.Name,
+			IndexMetadata: index.Metadata,
+		})
+	}
+
+	slices.SortFunc(statuses, func(a, b IndexStatus) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+
+	if cmd.IsSet(JsonFlag) {
+		enc := json.NewEncoder(Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	}
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tPACKAGES\tSKIPPED\tUPDATED\tDURATION\tDOWNLOAD\tDISK\tFETCHER\tLAST ERROR")
+	for _, st := range statuses {
+		lastErr := "-"
+		if st.LastError != "" && !st.LastErrorAt.Before(st.LastSuccessAt) {
+			lastErr = fmt.Sprintf("%s (%s)", st.LastError, formatAgo(now, st.LastErrorAt))
+		}
+		if now.Before(st.NextRetryAt) {
+			lastErr += fmt.Sprintf(", next retry in %s", st.NextRetryAt.Sub(now).Round(time.Second))
+		}
+
+		fmt.Fprintf(
+			tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			st.Index,
+			st.PackageCount,
+			st.SkippedCount,
+			formatAgo(now, st.LastIndexedAt),
+			st.IndexDuration.Round(time.Millisecond),
+			formatDownload(st.DownloadSize),
+			formatBytes(st.DiskSize),
+			cmp.Or(st.Fetcher, "-"),
+			lastErr,
+		)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// The table only has the counts, so list what was skipped below it
+	for _, st := range statuses {
+		if st.SkippedCount == 0 {
+			continue
+		}
+
+		fmt.Fprintf(Stdout, "\n%s: skipped %d packages\n", st.Index, st.SkippedCount)
+		for _, skipped := range st.Skipped {
+			fmt.Fprintf(Stdout, "  %s\n", skipped)
+		}
+		if more := st.SkippedCount - len(st.Skipped); more > 0 {
+			fmt.Fprintf(Stdout, "  ...and %d more\n", more)
+		}
+	}
+
+	return nil
+}
+
+func formatAgo(now, t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	return now.Sub(t).Round(time.Second).String() + " ago"
+}
+
+// formatDownload shows "-" for indexes built without
+// downloading anything, rather than a 0B download
+func formatDownload(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return formatBytes(n)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
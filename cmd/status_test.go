@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/3timeslazy/nix-search-tv/config"
+	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/indices"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/urfave/cli/v3"
+)
+
+func TestStatus(t *testing.T) {
+	state := setup(t)
+
+	writeXdgConfig(t, state, map[string]any{
+		config.EnableWaitingMessageTag: false,
+		"indexes":                      []string{indices.Nixpkgs, indices.HomeManager},
+	})
+
+	indices.SetFetchers(map[string]indexer.Fetcher{
+		indices.Nixpkgs: &ContentFetcher{pkgs: map[string]string{
+			"ripgrep": `{}`,
+			"fd":      `{}`,
+		}},
+		indices.HomeManager: &ContentFetcher{pkgs: map[string]string{}},
+	})
+
+	printCmd(t, "--indexes", indices.Nixpkgs)
+	state.Stdout.Reset()
+
+	statusCmd(t, "--json")
+
+	statuses := []IndexStatus{}
+	assert.NoError(t, json.Unmarshal(state.Stdout.Bytes(), &statuses))
+	assert.Equal(t, 2, len(statuses))
+
+	assert.Equal(t, indices.HomeManager, statuses[0].Index)
+	assert.True(t, statuses[0].LastIndexedAt.IsZero())
+
+	assert.Equal(t, indices.Nixpkgs, statuses[1].Index)
+	assert.Equal(t, 2, statuses[1].PackageCount)
+	assert.Equal(t, "cmd.ContentFetcher", statuses[1].Fetcher)
+	// Nothing came over HTTP
+	assert.Equal(t, int64(0), statuses[1].DownloadSize)
+	assert.True(t, statuses[1].DiskSize > 0)
+	assert.False(t, statuses[1].LastSuccessAt.IsZero())
+
+	t.Run("table", func(t *testing.T) {
+		state.Stdout.Reset()
+
+		statusCmd(t)
+
+		lines := strings.Split(strings.TrimSpace(state.Stdout.String()), "\n")
+		assert.Equal(t, 3, len(lines))
+		assert.True(t, strings.HasPrefix(lines[0], "INDEX"))
+		assert.Contains(t, lines[1], "never")
+		assert.Equal(t, []string{"nixpkgs", "2", "0"}, strings.Fields(lines[2])[:3])
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		md := statuses[1].IndexMetadata
+		md.SkippedCount = 3
+		md.Skipped = []string{"pkg1: duplicate package name", "pkg2: invalid package content"}
+		setMetadata(t, state, indices.Nixpkgs, md)
+		state.Stdout.Reset()
+
+		statusCmd(t)
+
+		out := state.Stdout.String()
+		assert.Contains(t, out, "nixpkgs: skipped 3 packages\n")
+		assert.Contains(t, out, "  pkg1: duplicate package name\n  pkg2: invalid package content\n  ...and 1 more\n")
+	})
+}
+
+func statusCmd(t *testing.T, args ...string) {
+	cmd := cli.Command{
+		Writer: io.Discard,
+		Flags: append(
+			BaseFlags(),
+			&cli.BoolFlag{Name: JsonFlag},
+		),
+		Action: StatusAction,
+	}
+	err := cmd.Run(context.TODO(), append([]string{"status"}, args...))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/3timeslazy/nix-search-tv/indexer"
+
+	"github.com/urfave/cli/v3"
+)
+
+const ForceFlag = "force"
+
+var Update = &cli.Command{
+	Name:      "update",
+	UsageText: "nix-search-tv update [--indexes ...] [--force] [--offline]",
+	Usage:     "Check for new releases and re-index the indexes that changed, showing progress. Exits with 1 if any index fails",
+	Action:    UpdateAction,
+	Flags: append(
+		BaseFlags(),
+		&cli.BoolFlag{
+			Name:  ForceFlag,
+			Usage: "re-index even if the releases have not changed",
+		},
+		&cli.BoolFlag{
+			Name:  OfflineFlag,
+			Usage: "re-index from the stored artifacts of the last releases without network access. Requires --force",
+		},
+	),
+}
+
+func UpdateAction(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Bool(OfflineFlag) && !cmd.Bool(ForceFlag) {
+		return cli.Exit("--offline only rebuilds the indexes and requires --force", 1)
+	}
+
+	conf, err := GetConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("get config: %w", err)
+	}
+
+	available, err := SetupIndexes(conf)
+	if err != nil {
+		return fmt.Errorf("register fetchers: %w", err)
+	}
+
+	indexes, err := GetIndexes(conf, requestedIndexes(cmd, conf, available))
+	if err != nil {
+		return fmt.Errorf("get indexes: %w", err)
+	}
+
+	printer := newProgressPrinter(Stderr)
+	for i := range indexes {
+		indexes[i].Force = cmd.Bool(ForceFlag)
+		indexes[i].Offline = cmd.Bool(OfflineFlag)
+		indexes[i].Progress = printer.Update
+	}
+
+	failed := []string{}
+	for result := range indexer.RunIndexing(ctx, conf.CacheDir, indexes) {
+		printer.Finish(result)
+		if result.Err != nil {
+			failed = append(failed, result.Index)
+		}
+	}
+
+	if len(failed) > 0 {
+		slices.Sort(failed)
+		return cli.Exit("failed to update: "+strings.Join(failed, ", "), 1)
+	}
+
+	return nil
+}
+
+// progressPrinter shows the progress of all the running indexes in a single
+// line, which is redrawn on every update. When the output is not a terminal,
+// e.g. in systemd or CI logs, only the final result of every index is printed
+type progressPrinter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	isTTY   bool
+	running map[string]indexer.Progress
+	// indexed are the indexes that got a new release
+	// and the last progress of their indexing
+	indexed map[string]indexer.Progress
+}
+
+func newProgressPrinter(out io.Writer) *progressPrinter {
+	isTTY := false
+	if f, ok := out.(*os.File); ok {
+		stat, err := f.Stat()
+		isTTY = err == nil && stat.Mode()&os.ModeCharDevice != 0
+	}
+
+	return &progressPrinter{
+		out:     out,
+		isTTY:   isTTY,
+		running: map[string]indexer.Progress{},
+		indexed: map[string]indexer.Progress{},
+	}
+}
+
+func (p *progressPrinter) Update(prog indexer.Progress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.indexed[prog.Index]; ok || prog.Phase == indexer.PhaseDownloading {
+		p.indexed[prog.Index] = prog
+	}
+
+	if prog.Phase == indexer.PhaseDone {
+		delete(p.running, prog.Index)
+	} else {
+		p.running[prog.Index] = prog
+	}
+
+	p.redraw()
+}
+
+func (p *progressPrinter) Finish(result indexer.IndexingResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+
+	prog, indexed := p.indexed[result.Index]
+	switch {
+	case result.Err != nil:
+		fmt.Fprintf(p.out, "%s: indexing failed: %s\n", result.Index, result.Err)
+	case indexed:
+		fmt.Fprintf(
+			p.out, "%s: indexed %d packages (%s) in %s\n",
+			result.Index, prog.Packages, formatBytes(prog.Bytes), prog.Elapsed.Round(time.Millisecond),
+		)
+	default:
+		fmt.Fprintf(p.out, "%s: up to date\n", result.Index)
+	}
+
+	p.redraw()
+}
+
+func (p *progressPrinter) redraw() {
+	if !p.isTTY || len(p.running) == 0 {
+		return
+	}
+
+	names := slices.Sorted(func(yield func(string) bool) {
+		for name := range p.running {
+			if !yield(name) {
+				return
+			}
+		}
+	})
+
+	parts := []string{}
+	for _, name := range names {
+		prog := p.running[name]
+		parts = append(parts, formatProgress(prog))
+	}
+
+	p.clear()
+	fmt.Fprint(p.out, strings.Join(parts, " | "))
+}
+
+func (p *progressPrinter) clear() {
+	if p.isTTY {
+		// Move to the beginning of the line and erase it
+		fmt.Fprint(p.out, "\r\033[K")
+	}
+}
+
+func formatProgress(prog indexer.Progress) string {
+	return fmt.Sprintf(
+		"%s: %s, %s, %d packages, %s",
+		prog.Index, prog.Phase, formatBytes(prog.Bytes), prog.Packages, prog.Elapsed.Round(time.Second),
+	)
+}
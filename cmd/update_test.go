@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/3timeslazy/nix-search-tv/config"
+	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/indices"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/urfave/cli/v3"
+)
+
+func TestUpdate(t *testing.T) {
+	setupUpdate := func(t *testing.T) state {
+		state := setup(t)
+
+		writeXdgConfig(t, state, map[string]any{
+			config.EnableWaitingMessageTag: false,
+			"indexes":                      []string{indices.Nixpkgs, indices.HomeManager},
+		})
+
+		indices.SetFetchers(map[string]indexer.Fetcher{
+			indices.Nixpkgs:     &PkgsFetcher{pkgs: []string{"ripgrep", "fd"}},
+			indices.HomeManager: &FailFetcher{},
+		})
+
+		return state
+	}
+
+	t.Run("progress and exit code", func(t *testing.T) {
+		state := setupUpdate(t)
+
+		err := updateCmd(t)
+		var exitErr cli.ExitCoder
+		assert.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, 1, exitErr.ExitCode())
+		assert.Contains(t, err.Error(), indices.HomeManager)
+
+		lines := strings.Split(strings.TrimSpace(state.Stderr.String()), "\n")
+		slices.Sort(lines)
+		assert.Equal(t, 2, len(lines))
+		assert.Equal(t, "home-manager: indexing failed: get latest release: failed to get latest release", lines[0])
+		assert.True(t, strings.HasPrefix(lines[1], "nixpkgs: indexed 2 packages ("))
+
+		assert.Equal(t, "", state.Stdout.String())
+	})
+
+	t.Run("up to date unless forced", func(t *testing.T) {
+		state := setupUpdate(t)
+
+		assert.NoError(t, updateCmd(t, "--indexes", indices.Nixpkgs))
+		state.Stderr.Reset()
+
+		assert.NoError(t, updateCmd(t, "--indexes", indices.Nixpkgs))
+		assert.Equal(t, "nixpkgs: up to date\n", state.Stderr.String())
+		state.Stderr.Reset()
+
+		assert.NoError(t, updateCmd(t, "--indexes", indices.Nixpkgs, "--force"))
+		assert.True(t, strings.HasPrefix(state.Stderr.String(), "nixpkgs: indexed 2 packages"))
+	})
+
+	t.Run("offline rebuild from artifact", func(t *testing.T) {
+		state := setupUpdate(t)
+
+		assert.NoError(t, updateCmd(t, "--indexes", indices.Nixpkgs))
+		state.Stderr.Reset()
+
+		// No network access, only the stored artifact
+		indices.SetFetchers(map[string]indexer.Fetcher{
+			indices.Nixpkgs: &FailFetcher{},
+		})
+
+		err := updateCmd(t, "--indexes", indices.Nixpkgs, "--offline")
+		assert.Error(t, err)
+
+		assert.NoError(t, updateCmd(t, "--indexes", indices.Nixpkgs, "--offline", "--force"))
+		assert.True(t, strings.HasPrefix(state.Stderr.String(), "nixpkgs: indexed 2 packages"))
+	})
+}
+
+func updateCmd(t *testing.T, args ...string) error {
+	cmd := cli.Command{
+		Writer: io.Discard,
+		Flags: append(
+			BaseFlags(),
+			&cli.BoolFlag{Name: ForceFlag},
+			&cli.BoolFlag{Name: OfflineFlag},
+		),
+		Action: UpdateAction,
+		// The default handler exits the process on cli.Exit errors
+		ExitErrHandler: func(context.Context, *cli.Command, error) {},
+	}
+	return cmd.Run(context.TODO(), append([]string{"update"}, args...))
+}
@@ -14,12 +14,14 @@ import (
 	"github.com/3timeslazy/nix-search-tv/indexer"
 	"github.com/3timeslazy/nix-search-tv/indexes/indices"
 	"github.com/alecthomas/assert/v2"
+	"github.com/urfave/cli/v3"
 )
 
 type state struct {
 	CacheDir  string
 	ConfigDir string
 	Stdout    *bytes.Buffer
+	Stderr    *bytes.Buffer
 }
 
 func setup(t *testing.T) state {
@@ -35,9 +37,16 @@ func setup(t *testing.T) state {
 
 	buf := bytes.NewBuffer(nil)
 	Stdout = buf
+	errBuf := bytes.NewBuffer(nil)
+	Stderr = errBuf
 
 	indices.Reset()
 
+	// Never spawn the test binary
+	startBackgroundUpdate = func(*cli.Command, []indexer.Index) error {
+		return nil
+	}
+
 	t.Cleanup(func() {
 		assert.NoError(t, os.RemoveAll(cacheDir))
 		assert.NoError(t, os.RemoveAll(configDir))
@@ -50,6 +59,7 @@ func setup(t *testing.T) state {
 		assert.NoError(t, err)
 
 		Stdout = nil
+		Stderr = os.Stderr
 
 		indices.Reset()
 	})
@@ -58,6 +68,7 @@ func setup(t *testing.T) state {
 		CacheDir:  cacheDir,
 		ConfigDir: configDir,
 		Stdout:    buf,
+		Stderr:    errBuf,
 	}
 }
 
@@ -80,6 +91,7 @@ func getCache(t *testing.T, state state) []string {
 		state.CacheDir,
 		"nix-search-tv",
 		indices.Nixpkgs,
+		"current",
 		"cache.txt",
 	)
 	cacheb, err := os.ReadFile(path)
@@ -128,3 +140,27 @@ func (f *PkgsFetcher) DownloadRelease(ctx context.Context, release string) (io.R
 
 	return io.NopCloser(bytes.NewBuffer(data)), nil
 }
+
+// ContentFetcher is like PkgsFetcher, but with
+// the content of the packages
+type ContentFetcher struct {
+	pkgs map[string]string
+}
+
+func (f *ContentFetcher) GetLatestRelease(ctx context.Context, md indexer.IndexMetadata) (string, error) {
+	return "latest", nil
+}
+
+func (f *ContentFetcher) DownloadRelease(ctx context.Context, release string) (io.ReadCloser, error) {
+	pkgs := indexer.Indexable{Packages: map[string]json.RawMessage{}}
+	for name, content := range f.pkgs {
+		pkgs.Packages[name] = []byte(content)
+	}
+
+	data, err := json.Marshal(pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewBuffer(data)), nil
+}
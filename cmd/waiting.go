@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/3timeslazy/nix-search-tv/config"
+	"github.com/3timeslazy/nix-search-tv/indexer"
 	"github.com/3timeslazy/nix-search-tv/style"
 )
 
@@ -48,6 +49,21 @@ func PreviewWaiting(out io.Writer, conf config.Config) {
 	s := []string{
 		nixLogo,
 		"Looking for packages updates... It shouldn't take more than a few seconds.",
+	}
+
+	// If some indexes are being indexed right now, show
+	// how far they went instead of guessing
+	running, _ := indexer.ReadProgress(conf.CacheDir)
+	if len(running) > 0 {
+		s[1] = "Looking for packages updates..."
+		s = append(s, "")
+		for _, prog := range running {
+			s = append(s, "  "+formatProgress(prog))
+		}
+		s = append(s, "")
+	}
+
+	s = append(s,
 		"Next time, this message won't be here until the next indexing",
 		"",
 		"Indexing happens in two cases:",
@@ -61,7 +77,7 @@ func PreviewWaiting(out io.Writer, conf config.Config) {
 		"  https://github.com/3timeslazy/nix-search-tv",
 		"",
 		"Thank you for using nix-search-tv!",
-	}
+	)
 
 	msg := strings.Join(s, "\n")
 	out.Write([]byte(msg))
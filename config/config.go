@@ -11,30 +11,89 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/3timeslazy/nix-search-tv/indexer"
 	"github.com/3timeslazy/nix-search-tv/indexes/indices"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
 )
 
 // Config represents configuration options stored in the
 // config file
 type Config struct {
-	UpdateInterval       Duration     `json:"update_interval"`
-	CacheDir             string       `json:"cache_dir"`
-	EnableWaitingMessage bool         `json:"enable_waiting_message"`
-	Indexes              []string     `json:"indexes"`
-	Experimental         Experimental `json:"experimental"`
+	UpdateInterval       Duration                `json:"update_interval"`
+	CacheDir             string                  `json:"cache_dir"`
+	EnableWaitingMessage bool                    `json:"enable_waiting_message"`
+	Indexes              []string                `json:"indexes"`
+	Store                string                  `json:"store"`
+	Channels             map[string]string       `json:"channels"`
+	ChannelIndexes       map[string]ChannelIndex `json:"channel_indexes"`
+	S3Endpoint           string                  `json:"s3_endpoint"`
+	Mirrors              map[string]string       `json:"mirrors"`
+	HTTP                 HTTP                    `json:"http"`
+	MaxArtifactSizeMB    int                     `json:"max_artifact_size_mb"`
+	GitHubToken          string                  `json:"github_token"`
+	LenientIndexes       []string                `json:"lenient_indexes"`
+	Experimental         Experimental            `json:"experimental"`
+}
+
+// HTTP configures the client all the indexes are downloaded with
+type HTTP struct {
+	ConnectTimeout Duration `json:"connect_timeout"`
+	ReadTimeout    Duration `json:"read_timeout"`
+	Retries        int      `json:"retries"`
+	Proxy          string   `json:"proxy"`
+	CAFiles        []string `json:"ca_files"`
+	UserAgent      string   `json:"user_agent"`
+}
+
+type httpConfig struct {
+	ConnectTimeout *Duration `json:"connect_timeout"`
+	ReadTimeout    *Duration `json:"read_timeout"`
+	Retries        *int      `json:"retries"`
+	Proxy          string    `json:"proxy"`
+	CAFiles        []string  `json:"ca_files"`
+	UserAgent      *string   `json:"user_agent"`
+}
+
+// ChannelIndex is an extra index of nixpkgs packages
+// or NixOS options of another channel
+type ChannelIndex struct {
+	// Index is the builtin index to take the fetcher and
+	// preview from. Either "nixpkgs" or "nixos"
+	Index   string `json:"index"`
+	Channel string `json:"channel"`
 }
 
 type config struct {
-	UpdateInterval       *Duration    `json:"update_interval"`
-	CacheDir             *string      `json:"cache_dir"`
-	EnableWaitingMessage *bool        `json:"enable_waiting_message"`
-	Indexes              *[]string    `json:"indexes"`
-	Experimental         Experimental `json:"experimental"`
+	UpdateInterval       *Duration               `json:"update_interval"`
+	CacheDir             *string                 `json:"cache_dir"`
+	EnableWaitingMessage *bool                   `json:"enable_waiting_message"`
+	Indexes              *[]string               `json:"indexes"`
+	Store                *string                 `json:"store"`
+	Channels             map[string]string       `json:"channels"`
+	ChannelIndexes       map[string]ChannelIndex `json:"channel_indexes"`
+	S3Endpoint           *string                 `json:"s3_endpoint"`
+	Mirrors              map[string]string       `json:"mirrors"`
+	HTTP                 httpConfig              `json:"http"`
+	MaxArtifactSizeMB    *int                    `json:"max_artifact_size_mb"`
+	GitHubToken          string                  `json:"github_token"`
+	LenientIndexes       []string                `json:"lenient_indexes"`
+	Experimental         Experimental            `json:"experimental"`
 }
 
 type Experimental struct {
-	RenderDocsIndexes map[string]string `json:"render_docs_indexes"`
-	OptionsFile       map[string]string `json:"options_file"`
+	RenderDocsIndexes map[string]string    `json:"render_docs_indexes"`
+	OptionsFile       map[string]string    `json:"options_file"`
+	JSONIndexes       map[string]JSONIndex `json:"json_indexes"`
+}
+
+// JSONIndex is an index of the packages of an arbitrary JSON
+// document. See jsonstream.Options for the fields
+type JSONIndex struct {
+	// Source is either the URL or the path of the document
+	Source   string `json:"source"`
+	Pointer  string `json:"pointer"`
+	Format   string `json:"format"`
+	KeyField string `json:"key_field"`
 }
 
 // Keep the constants below in sync with the `Config` json tags
@@ -96,10 +155,40 @@ func mergeDefaults(loaded config) Config {
 	if loaded.EnableWaitingMessage != nil {
 		conf.EnableWaitingMessage = *loaded.EnableWaitingMessage
 	}
+	if loaded.Store != nil {
+		conf.Store = *loaded.Store
+	}
+	if loaded.S3Endpoint != nil {
+		conf.S3Endpoint = *loaded.S3Endpoint
+	}
+	conf.Channels = loaded.Channels
+	conf.ChannelIndexes = loaded.ChannelIndexes
+	conf.Mirrors = loaded.Mirrors
+	conf.GitHubToken = loaded.GitHubToken
+	conf.LenientIndexes = loaded.LenientIndexes
+	if loaded.MaxArtifactSizeMB != nil {
+		conf.MaxArtifactSizeMB = *loaded.MaxArtifactSizeMB
+	}
+
+	if loaded.HTTP.ConnectTimeout != nil {
+		conf.HTTP.ConnectTimeout = *loaded.HTTP.ConnectTimeout
+	}
+	if loaded.HTTP.ReadTimeout != nil {
+		conf.HTTP.ReadTimeout = *loaded.HTTP.ReadTimeout
+	}
+	if loaded.HTTP.Retries != nil {
+		conf.HTTP.Retries = *loaded.HTTP.Retries
+	}
+	if loaded.HTTP.UserAgent != nil {
+		conf.HTTP.UserAgent = *loaded.HTTP.UserAgent
+	}
+	conf.HTTP.Proxy = loaded.HTTP.Proxy
+	conf.HTTP.CAFiles = loaded.HTTP.CAFiles
 
 	conf.Experimental = Experimental{
 		RenderDocsIndexes: loaded.Experimental.RenderDocsIndexes,
 		OptionsFile:       loaded.Experimental.OptionsFile,
+		JSONIndexes:       loaded.Experimental.JSONIndexes,
 	}
 
 	return conf
@@ -122,11 +211,21 @@ func defaults() Config {
 		indexes = append(indexes, indices.Darwin)
 	}
 
+	httpDefaults := httpclient.DefaultConfig()
+
 	return Config{
 		UpdateInterval:       Duration(time.Hour * 24 * 7),
 		CacheDir:             cacheDir,
 		EnableWaitingMessage: true,
 		Indexes:              indexes,
+		Store:                indexer.StoreBadger,
+		MaxArtifactSizeMB:    256,
+		HTTP: HTTP{
+			ConnectTimeout: Duration(httpDefaults.ConnectTimeout),
+			ReadTimeout:    Duration(httpDefaults.ReadTimeout),
+			Retries:        httpDefaults.Retries,
+			UserAgent:      httpDefaults.UserAgent,
+		},
 	}
 }
 
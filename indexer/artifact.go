@@ -0,0 +1,135 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/andybalholm/brotli"
+)
+
+// artifactFile keeps the packages of the last indexed release exactly as
+// the fetcher returned them, so that the index can be rebuilt without
+// downloading them again, e.g. after a corruption or a format change
+const artifactFile = "artifact.json.br"
+
+// The artifact is written while indexing, so the compression
+// must be fast enough not to slow the indexing down
+const artifactCompression = 4
+
+var ErrNoArtifact = errors.New("no stored release artifact")
+
+// artifactWriter compresses everything written into it into a temporary
+// file. It never fails the writes, so that indexing goes on even if
+// the artifact can't be kept, e.g. because it's bigger than allowed
+type artifactWriter struct {
+	tmp     *os.File
+	path    string
+	maxSize int64
+	size    int64
+	br      *brotli.Writer
+	err     error
+}
+
+func newArtifactWriter(indexDir string, maxSize int64) *artifactWriter {
+	w := &artifactWriter{
+		path:    filepath.Join(indexDir, artifactFile),
+		maxSize: maxSize,
+	}
+
+	w.tmp, w.err = os.CreateTemp(indexDir, artifactFile+".tmp")
+	if w.err == nil {
+		w.br = brotli.NewWriterLevel(&sizeWriter{wr: w.tmp, n: &w.size}, artifactCompression)
+	}
+
+	return w
+}
+
+func (w *artifactWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return len(p), nil
+	}
+
+	if _, err := w.br.Write(p); err != nil {
+		w.err = err
+	}
+	if w.size > w.maxSize {
+		w.err = fmt.Errorf("artifact is bigger than %s", formatSize(w.maxSize))
+	}
+
+	return len(p), nil
+}
+
+// commit replaces the previous artifact with the written one
+func (w *artifactWriter) commit() error {
+	if w.err == nil {
+		w.err = w.br.Close()
+	}
+	if w.err == nil && w.size > w.maxSize {
+		w.err = fmt.Errorf("artifact is bigger than %s", formatSize(w.maxSize))
+	}
+	if w.err == nil {
+		w.err = w.tmp.Close()
+	}
+	if w.err == nil {
+		w.err = os.Rename(w.tmp.Name(), w.path)
+	}
+	if w.err != nil {
+		w.discard()
+		// The previous artifact is of another release
+		// now, it can't be used anymore
+		_ = os.Remove(w.path)
+	}
+
+	return w.err
+}
+
+func (w *artifactWriter) discard() {
+	if w.tmp != nil {
+		w.tmp.Close()
+		_ = os.Remove(w.tmp.Name())
+	}
+}
+
+// openArtifact opens the stored packages of the last indexed release
+func openArtifact(indexDir string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(indexDir, artifactFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoArtifact
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &artifactReader{f: f, rd: brotli.NewReader(f)}, nil
+}
+
+type artifactReader struct {
+	f  *os.File
+	rd io.Reader
+}
+
+func (r *artifactReader) Read(p []byte) (int, error) {
+	return r.rd.Read(p)
+}
+
+func (r *artifactReader) Close() error {
+	return r.f.Close()
+}
+
+type sizeWriter struct {
+	wr io.Writer
+	n  *int64
+}
+
+func (w *sizeWriter) Write(p []byte) (int, error) {
+	n, err := w.wr.Write(p)
+	*w.n += int64(n)
+	return n, err
+}
+
+func formatSize(n int64) string {
+	return fmt.Sprintf("%dMiB", n>>20)
+}
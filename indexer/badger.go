@@ -3,6 +3,7 @@ package indexer
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 
@@ -46,28 +47,45 @@ type Indexable struct {
 	Packages map[string]json.RawMessage `json:"packages"`
 }
 
-func (indexer *Badger) Index(data io.Reader, indexedKeys io.Writer) error {
-	// Delete previous index. If we do not do that
-	// and just re-assign the keys below, the index
-	// will be updated, however its size will increase drastically.
-	// Then, to keep the index size small, we'll need to deal with
-	// badger's garbade colletion. So, it's just easier to drop everything
-	err := indexer.badger.DropAll()
-	if err != nil {
-		return fmt.Errorf("drop all: %w", err)
-	}
+// Index writes the packages from data into the index and the
+// package names into indexedKeys.
+//
+// The index is updated incrementally: only added or changed packages
+// are written and the packages missing from data are deleted. Rewriting
+// everything on every update makes the index size grow drastically
+// until badger's garbage collection catches up, while between two
+// nixpkgs releases usually only a few hundred packages change.
+func (indexer *Badger) Index(data io.Reader, opts jsonstream.Options, indexedKeys io.Writer) error {
+	// Read the previous state from a snapshot, so that
+	// the writes below do not affect the comparison
+	txn := indexer.badger.NewTransaction(false)
+	defer txn.Discard()
 
 	batch := indexer.badger.NewWriteBatch()
+	defer batch.Cancel()
+
+	seen := map[string]struct{}{}
+	changed := 0
 
-	err = jsonstream.ParsePackages(data, func(name string, content []byte) error {
+	err := jsonstream.Parse(data, opts, func(name string, content []byte) error {
 		nameb := []byte(name)
 
-		err := batch.Set(nameb, bytes.Clone(content))
+		same, err := sameValue(txn, nameb, content)
 		if err != nil {
-			return fmt.Errorf("set %s: %w", name, err)
+			return fmt.Errorf("compare %s: %w", name, err)
+		}
+		if !same {
+			err = batch.Set(nameb, bytes.Clone(content))
+			if err != nil {
+				return fmt.Errorf("set %s: %w", name, err)
+			}
+			changed++
 		}
 
-		indexedKeys.Write(append(nameb, []byte("\n")...))
+		// Only the packages that made it into the store, as
+		// the lenient parsing goes on after a failed one
+		seen[name] = struct{}{}
+		indexedKeys.Write(append(nameb, '\n'))
 
 		return nil
 	})
@@ -75,7 +93,66 @@ func (indexer *Badger) Index(data io.Reader, indexedKeys io.Writer) error {
 		return fmt.Errorf("handle packages: %w", err)
 	}
 
-	return batch.Flush()
+	iterOpts := badger.DefaultIteratorOptions
+	iterOpts.PrefetchValues = false
+	it := txn.NewIterator(iterOpts)
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := it.Item().KeyCopy(nil)
+		if _, ok := seen[string(key)]; ok {
+			continue
+		}
+
+		changed++
+		if err := batch.Delete(key); err != nil {
+			it.Close()
+			return fmt.Errorf("delete %s: %w", key, err)
+		}
+	}
+	it.Close()
+
+	if err := batch.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+
+	if changed == 0 || indexer.badger.Opts().InMemory {
+		return nil
+	}
+
+	return indexer.compact()
+}
+
+func sameValue(txn *badger.Txn, key, value []byte) (bool, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	same := false
+	err = item.Value(func(prev []byte) error {
+		same = bytes.Equal(prev, value)
+		return nil
+	})
+	return same, err
+}
+
+// compact drops overwritten and deleted packages from disk
+func (indexer *Badger) compact() error {
+	if err := indexer.badger.Flatten(1); err != nil {
+		return fmt.Errorf("flatten: %w", err)
+	}
+
+	for {
+		err := indexer.badger.RunValueLogGC(0.5)
+		if errors.Is(err, badger.ErrNoRewrite) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("value log gc: %w", err)
+		}
+	}
 }
 
 func (bdg *Badger) Load(pkgName string) (json.RawMessage, error) {
@@ -104,3 +181,43 @@ func (bdg *Badger) Load(pkgName string) (json.RawMessage, error) {
 func (bdg *Badger) Close() error {
 	return bdg.badger.Close()
 }
+
+func (bdg *Badger) Keys(fn func(key string) error) error {
+	return bdg.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := fn(string(it.Item().Key())); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (bdg *Badger) Scan(prefix string, fn func(key string, content []byte) error) error {
+	return bdg.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(prefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			err := item.Value(func(content []byte) error {
+				return fn(string(item.Key()), content)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,51 @@
+package indexer
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestBadgerIncrementalIndex(t *testing.T) {
+	indexer, err := NewBadger(BadgerConfig{
+		Dir: t.TempDir(),
+	})
+	assert.NoError(t, err)
+	defer indexer.Close()
+
+	index := func(data string) []string {
+		keys := bytes.Buffer{}
+		err := indexer.Index(strings.NewReader(data), jsonstream.Options{}, &keys)
+		assert.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(keys.String()), "\n")
+		slices.Sort(lines)
+		return lines
+	}
+
+	keys := index(`{"packages": {"pkg-a": {"v": 1}, "pkg-b": {"v": 1}}}`)
+	assert.Equal(t, []string{"pkg-a", "pkg-b"}, keys)
+
+	keys = index(`{"packages": {"pkg-b": {"v": 2}, "pkg-c": {"v": 1}}}`)
+	assert.Equal(t, []string{"pkg-b", "pkg-c"}, keys)
+
+	_, err = indexer.Load("pkg-a")
+	assert.Error(t, err)
+
+	pkg, err := indexer.Load("pkg-b")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"v": 2}`, string(pkg))
+
+	pkg, err = indexer.Load("pkg-c")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"v": 1}`, string(pkg))
+
+	// Nothing changed, nothing should break
+	keys = index(`{"packages": {"pkg-b": {"v": 2}, "pkg-c": {"v": 1}}}`)
+	assert.Equal(t, []string{"pkg-b", "pkg-c"}, keys)
+}
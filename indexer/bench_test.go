@@ -0,0 +1,99 @@
+package indexer
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
+
+	"github.com/andybalholm/brotli"
+)
+
+// fixtureFetcher serves a set of packages from the testdata of
+// the indexes, wrapped into the format of the indexer
+type fixtureFetcher struct {
+	path   string
+	brotli bool
+}
+
+func (f *fixtureFetcher) GetLatestRelease(context.Context, IndexMetadata) (string, error) {
+	return f.path, nil
+}
+
+func (f *fixtureFetcher) DownloadRelease(context.Context, string) (io.ReadCloser, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs io.Reader = file
+	if f.brotli {
+		pkgs = brotli.NewReader(file)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.MultiReader(strings.NewReader(`{"packages":`), pkgs, strings.NewReader(`}`)),
+		Closer: file,
+	}, nil
+}
+
+var fixtures = []struct {
+	name    string
+	fetcher *fixtureFetcher
+}{
+	{"nixos", &fixtureFetcher{path: "../indexes/nixos/testdata/options.br.json", brotli: true}},
+	{"home-manager", &fixtureFetcher{path: "../indexes/homemanager/testdata/options.json"}},
+}
+
+// BenchmarkRunIndex measures building an index from
+// scratch, as on the first run or after a format change
+func BenchmarkRunIndex(b *testing.B) {
+	for _, fixture := range fixtures {
+		b.Run(fixture.name, func(b *testing.B) {
+			for b.Loop() {
+				err := runIndex(context.Background(), b.TempDir(), Index{
+					Name:            "bench",
+					Fetcher:         fixture.fetcher,
+					Force:           true,
+					MaxArtifactSize: 256 << 20,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBadgerIndex measures only writing
+// the packages into an empty badger store
+func BenchmarkBadgerIndex(b *testing.B) {
+	for _, fixture := range fixtures {
+		b.Run(fixture.name, func(b *testing.B) {
+			for b.Loop() {
+				store, err := NewBadger(BadgerConfig{Dir: b.TempDir()})
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				pkgs, err := fixture.fetcher.DownloadRelease(context.Background(), "")
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				err = store.Index(pkgs, jsonstream.Options{}, io.Discard)
+				pkgs.Close()
+				store.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
+)
+
+const storeFile = "store.dat"
+
+var storeMagic = []byte("NSTVSTR1")
+
+var ErrKeyNotFound = errors.New("key not found")
+
+// FileStore keeps all the packages in a single file. Packages content
+// is appended to the file as it comes from the stream and is followed
+// by a table of package names sorted lexicographically with offsets
+// of their content:
+//
+//	magic
+//	content of pkg-b
+//	content of pkg-a
+//	...
+//	entries:       [name length u32][name][content offset u64][content length u32]
+//	                 (pkg-a)
+//	                 (pkg-b)
+//	                 ...
+//	entry offsets: [entry offset u64] for every entry
+//	footer:        [entries offset u64][entry offsets offset u64][count u64][magic]
+//
+// The fixed size entry offsets allow to binary search a package without
+// reading the whole table. The file is never modified in place, Index
+// writes a new one and renames it over the old one.
+//
+// For reading, the file is memory mapped and does not require any locks,
+// so any number of processes can read it at the same time
+type FileStore struct {
+	path string
+
+	// data is the memory mapped file
+	data   []byte
+	unmap  func() error
+	footer fileStoreFooter
+}
+
+type fileStoreFooter struct {
+	EntriesOffset uint64
+	OffsetsOffset uint64
+	Count         uint64
+}
+
+const fileStoreFooterSize = 8*3 + 8
+
+type fileStoreEntry struct {
+	key    string
+	offset uint64
+	length uint32
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+func (store *FileStore) Index(data io.Reader, opts jsonstream.Options, indexedKeys io.Writer) error {
+	wr, err := newFileStoreWriter(store.path)
+	if err != nil {
+		return err
+	}
+	defer wr.Abort()
+
+	err = jsonstream.Parse(data, opts, func(name string, content []byte) error {
+		if err := wr.Add(name, content); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+
+		indexedKeys.Write(append([]byte(name), '\n'))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("handle packages: %w", err)
+	}
+
+	// Re-open the store on the next read
+	if err := store.Close(); err != nil {
+		return fmt.Errorf("close previous store file: %w", err)
+	}
+
+	return wr.Finish()
+}
+
+// WriteLookupFile writes the content of the store into a
+// file in the FileStore format
+func WriteLookupFile(store Store, path string) error {
+	wr, err := newFileStoreWriter(path)
+	if err != nil {
+		return err
+	}
+	defer wr.Abort()
+
+	err = store.Scan("", func(key string, content []byte) error {
+		return wr.Add(key, content)
+	})
+	if err != nil {
+		return fmt.Errorf("scan store: %w", err)
+	}
+
+	return wr.Finish()
+}
+
+type fileStoreWriter struct {
+	path    string
+	tmp     *os.File
+	wr      *bufio.Writer
+	offset  uint64
+	entries []fileStoreEntry
+}
+
+func newFileStoreWriter(path string) (*fileStoreWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("cannot create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("create store file: %w", err)
+	}
+
+	wr := &fileStoreWriter{
+		path: path,
+		tmp:  tmp,
+		wr:   bufio.NewWriter(tmp),
+	}
+	if err := wr.write(storeMagic); err != nil {
+		wr.Abort()
+		return nil, fmt.Errorf("write magic: %w", err)
+	}
+
+	return wr, nil
+}
+
+func (wr *fileStoreWriter) write(b []byte) error {
+	n, err := wr.wr.Write(b)
+	wr.offset += uint64(n)
+	return err
+}
+
+func (wr *fileStoreWriter) Add(key string, content []byte) error {
+	wr.entries = append(wr.entries, fileStoreEntry{
+		key:    key,
+		offset: wr.offset,
+		length: uint32(len(content)),
+	})
+	return wr.write(content)
+}
+
+// Finish writes the entries table and moves the file to its place
+func (wr *fileStoreWriter) Finish() error {
+	slices.SortFunc(wr.entries, func(a, b fileStoreEntry) int {
+		return strings.Compare(a.key, b.key)
+	})
+
+	footer := fileStoreFooter{
+		EntriesOffset: wr.offset,
+		Count:         uint64(len(wr.entries)),
+	}
+	entryOffsets := make([]uint64, 0, len(wr.entries))
+	for _, entry := range wr.entries {
+		entryOffsets = append(entryOffsets, wr.offset)
+
+		buf := binary.LittleEndian.AppendUint32(nil, uint32(len(entry.key)))
+		buf = append(buf, entry.key...)
+		buf = binary.LittleEndian.AppendUint64(buf, entry.offset)
+		buf = binary.LittleEndian.AppendUint32(buf, entry.length)
+		if err := wr.write(buf); err != nil {
+			return fmt.Errorf("write entry: %w", err)
+		}
+	}
+
+	footer.OffsetsOffset = wr.offset
+	if err := binary.Write(wr.wr, binary.LittleEndian, entryOffsets); err != nil {
+		return fmt.Errorf("write entry offsets: %w", err)
+	}
+	if err := binary.Write(wr.wr, binary.LittleEndian, footer); err != nil {
+		return fmt.Errorf("write footer: %w", err)
+	}
+	if _, err := wr.wr.Write(storeMagic); err != nil {
+		return fmt.Errorf("write magic: %w", err)
+	}
+
+	if err := wr.wr.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	if err := wr.tmp.Close(); err != nil {
+		return fmt.Errorf("close store file: %w", err)
+	}
+
+	return os.Rename(wr.tmp.Name(), wr.path)
+}
+
+// Abort removes the unfinished file. It is a no-op after Finish
+func (wr *fileStoreWriter) Abort() {
+	wr.tmp.Close()
+	os.Remove(wr.tmp.Name())
+}
+
+func (store *FileStore) Load(key string) (json.RawMessage, error) {
+	if err := store.open(); err != nil {
+		return nil, err
+	}
+
+	i, err := store.search(key)
+	if err != nil {
+		return nil, err
+	}
+	if i == store.footer.Count {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+
+	entry, err := store.entry(i)
+	if err != nil {
+		return nil, err
+	}
+	if entry.key != key {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+
+	content, err := store.content(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// The mapped memory is gone after Close
+	return bytes.Clone(content), nil
+}
+
+func (store *FileStore) Keys(fn func(key string) error) error {
+	return store.scan("", false, func(key string, _ []byte) error {
+		return fn(key)
+	})
+}
+
+func (store *FileStore) Scan(prefix string, fn func(key string, content []byte) error) error {
+	return store.scan(prefix, true, fn)
+}
+
+func (store *FileStore) scan(prefix string, withContent bool, fn func(key string, content []byte) error) error {
+	if err := store.open(); err != nil {
+		return err
+	}
+
+	i, err := store.search(prefix)
+	if err != nil {
+		return err
+	}
+
+	for ; i < store.footer.Count; i++ {
+		entry, err := store.entry(i)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(entry.key, prefix) {
+			return nil
+		}
+
+		var content []byte
+		if withContent {
+			content, err = store.content(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := fn(entry.key, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of packages in the store
+func (store *FileStore) Len() (int, error) {
+	if err := store.open(); err != nil {
+		return 0, err
+	}
+
+	return int(store.footer.Count), nil
+}
+
+func (store *FileStore) Close() error {
+	if store.unmap == nil {
+		return nil
+	}
+
+	err := store.unmap()
+	store.data = nil
+	store.unmap = nil
+	return err
+}
+
+func (store *FileStore) open() error {
+	if store.unmap != nil {
+		return nil
+	}
+
+	data, unmap, err := mmapFile(store.path)
+	if err != nil {
+		return fmt.Errorf("open store file: %w", err)
+	}
+
+	footer, err := readFileStoreFooter(data)
+	if err != nil {
+		unmap()
+		return err
+	}
+
+	store.data = data
+	store.unmap = unmap
+	store.footer = footer
+	return nil
+}
+
+var errCorruptedStore = errors.New("store file is corrupted")
+
+func readFileStoreFooter(data []byte) (fileStoreFooter, error) {
+	footer := fileStoreFooter{}
+
+	if len(data) < len(storeMagic)+fileStoreFooterSize {
+		return footer, fmt.Errorf("%w: too small", errCorruptedStore)
+	}
+
+	buf := data[len(data)-fileStoreFooterSize:]
+	if !bytes.Equal(buf[fileStoreFooterSize-len(storeMagic):], storeMagic) {
+		return footer, fmt.Errorf("%w: bad magic", errCorruptedStore)
+	}
+
+	err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &footer)
+	if err != nil {
+		return footer, fmt.Errorf("decode footer: %w", err)
+	}
+	if footer.OffsetsOffset+footer.Count*8 > uint64(len(data)) {
+		return footer, fmt.Errorf("%w: bad footer", errCorruptedStore)
+	}
+
+	return footer, nil
+}
+
+// search returns the index of the first entry that is
+// not less than key
+func (store *FileStore) search(key string) (uint64, error) {
+	var searchErr error
+
+	lo, hi := uint64(0), store.footer.Count
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		entry, err := store.entry(mid)
+		if err != nil {
+			searchErr = err
+			break
+		}
+
+		if entry.key < key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo, searchErr
+}
+
+func (store *FileStore) entry(i uint64) (fileStoreEntry, error) {
+	entry := fileStoreEntry{}
+
+	pos := store.footer.OffsetsOffset + i*8
+	offset := binary.LittleEndian.Uint64(store.data[pos:])
+	if offset+4 > store.footer.OffsetsOffset {
+		return entry, fmt.Errorf("%w: bad entry offset", errCorruptedStore)
+	}
+
+	keyLen := uint64(binary.LittleEndian.Uint32(store.data[offset:]))
+	if offset+4+keyLen+8+4 > store.footer.OffsetsOffset {
+		return entry, fmt.Errorf("%w: bad key length", errCorruptedStore)
+	}
+	rest := store.data[offset+4:]
+
+	entry.key = string(rest[:keyLen])
+	entry.offset = binary.LittleEndian.Uint64(rest[keyLen:])
+	entry.length = binary.LittleEndian.Uint32(rest[keyLen+8:])
+	return entry, nil
+}
+
+func (store *FileStore) content(entry fileStoreEntry) ([]byte, error) {
+	end := entry.offset + uint64(entry.length)
+	if end > store.footer.EntriesOffset {
+		return nil, fmt.Errorf("%w: bad content offset", errCorruptedStore)
+	}
+
+	return store.data[entry.offset:end], nil
+}
@@ -0,0 +1,79 @@
+package indexer
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestFileStore(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), storeFile))
+	defer store.Close()
+
+	keys := bytes.Buffer{}
+	err := store.Index(strings.NewReader(`{
+		"packages": {
+			"pkg-b": {"v": "b"},
+			"pkg-a": {"v": "a"},
+			"other": {"v": "other"}
+		}
+	}`), jsonstream.Options{}, &keys)
+	assert.NoError(t, err)
+	assert.Equal(t, "pkg-b\npkg-a\nother\n", keys.String())
+
+	pkg, err := store.Load("pkg-a")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"v": "a"}`, string(pkg))
+
+	_, err = store.Load("pkg")
+	assert.IsError(t, err, ErrKeyNotFound)
+
+	_, err = store.Load("zzz")
+	assert.IsError(t, err, ErrKeyNotFound)
+
+	allKeys := []string{}
+	err = store.Keys(func(key string) error {
+		allKeys = append(allKeys, key)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other", "pkg-a", "pkg-b"}, allKeys)
+
+	scanned := map[string]string{}
+	err = store.Scan("pkg-", func(key string, content []byte) error {
+		scanned[key] = string(content)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"pkg-a": `{"v": "a"}`,
+		"pkg-b": `{"v": "b"}`,
+	}, scanned)
+
+	// Re-indexing replaces the content
+	err = store.Index(strings.NewReader(`{"packages": {"pkg-c": {}}}`), jsonstream.Options{}, &keys)
+	assert.NoError(t, err)
+
+	_, err = store.Load("pkg-a")
+	assert.IsError(t, err, ErrKeyNotFound)
+
+	pkg, err = store.Load("pkg-c")
+	assert.NoError(t, err)
+	assert.Equal(t, `{}`, string(pkg))
+}
+
+func TestFileStoreEmpty(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), storeFile))
+	defer store.Close()
+
+	err := store.Index(strings.NewReader(`{"packages": {}}`), jsonstream.Options{}, &bytes.Buffer{})
+	assert.NoError(t, err)
+
+	_, err = store.Load("pkg")
+	assert.IsError(t, err, ErrKeyNotFound)
+}
@@ -0,0 +1,413 @@
+package indexer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// termsFile is an inverted index from the words of packages
+// descriptions to the packages.
+//
+// It is stored in the FileStore format, where the keys are the terms and
+// the content is the list of packages ordinals in the lookup file, i.e
+// positions of the packages in the lexicographically sorted list
+// of package names. The ordinals are stored as uvarint deltas
+const termsFile = "terms.dat"
+
+var ErrNoTextIndex = errors.New("index has no full-text index, re-index it first")
+
+// stopWords are too common to be useful for search
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "be": true,
+	"by": true, "for": true, "if": true, "in": true, "is": true, "it": true,
+	"of": true, "on": true, "or": true, "the": true, "this": true, "to": true,
+	"with": true,
+}
+
+// termsCollector builds the inverted index while the packages are being
+// indexed.
+//
+// The ordinals are the positions in the sorted list of names, so they
+// are only known once all the packages are. Until then, the terms are
+// kept by the package name, as ids of the terms to save the memory
+type termsCollector struct {
+	ids   map[string]uint32
+	terms []string
+	pkgs  map[string][]uint32
+}
+
+func newTermsCollector() *termsCollector {
+	return &termsCollector{
+		ids:  map[string]uint32{},
+		pkgs: map[string][]uint32{},
+	}
+}
+
+// add tokenizes the package. It's meant for jsonstream.Options.Handled,
+// so it keeps nothing of the content
+func (c *termsCollector) add(name string, content []byte) {
+	terms := Tokenize(packageText(content))
+	slices.Sort(terms)
+
+	ids := make([]uint32, 0, len(terms))
+	for _, term := range slices.Compact(terms) {
+		id, ok := c.ids[term]
+		if !ok {
+			id = uint32(len(c.terms))
+			c.ids[term] = id
+			c.terms = append(c.terms, term)
+		}
+		ids = append(ids, id)
+	}
+	c.pkgs[name] = ids
+}
+
+// write writes the terms file. count is the number of packages in the
+// lookup file, which must be the ones collected for the ordinals to match
+func (c *termsCollector) write(path string, count int) error {
+	if len(c.pkgs) != count {
+		return fmt.Errorf("collected terms of %d packages, but indexed %d", len(c.pkgs), count)
+	}
+
+	postings := make([][]uint32, len(c.terms))
+	for ord, name := range slices.Sorted(maps.Keys(c.pkgs)) {
+		for _, id := range c.pkgs[name] {
+			postings[id] = append(postings[id], uint32(ord))
+		}
+	}
+
+	wr, err := newFileStoreWriter(path)
+	if err != nil {
+		return err
+	}
+	defer wr.Abort()
+
+	for id, ords := range postings {
+		buf := []byte{}
+		prev := uint32(0)
+		for _, ord := range ords {
+			buf = binary.AppendUvarint(buf, uint64(ord-prev))
+			prev = ord
+		}
+
+		if err := wr.Add(c.terms[id], buf); err != nil {
+			return fmt.Errorf("write %s: %w", c.terms[id], err)
+		}
+	}
+
+	return wr.Finish()
+}
+
+// packageText returns the searchable text of a package. The content comes
+// from different indexes, so just look for the fields they have in common
+func packageText(content []byte) string {
+	fields := struct {
+		Description     json.RawMessage `json:"description"`
+		LongDescription json.RawMessage `json:"longDescription"`
+		Type            json.RawMessage `json:"type"`
+		Example         json.RawMessage `json:"example"`
+		Meta            struct {
+			Description     json.RawMessage `json:"description"`
+			LongDescription json.RawMessage `json:"longDescription"`
+		} `json:"meta"`
+		// noogle
+		Content struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"content"`
+	}{}
+
+	// Packages that cannot be decoded are not searchable,
+	// but this is not a reason to fail the indexing
+	_ = json.Unmarshal(content, &fields)
+
+	texts := []string{}
+	for _, raw := range []json.RawMessage{
+		fields.Description,
+		fields.LongDescription,
+		fields.Type,
+		fields.Example,
+		fields.Meta.Description,
+		fields.Meta.LongDescription,
+		fields.Content.Content,
+	} {
+		if text := rawText(raw); text != "" {
+			texts = append(texts, text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// rawText decodes either a string or an object with
+// the "text" field, like examples in options.json
+func rawText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	s := ""
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+
+	text := struct {
+		Text string `json:"text"`
+	}{}
+	_ = json.Unmarshal(raw, &text)
+	return text.Text
+}
+
+// Tokenize splits the text into lowercase words, skipping HTML
+// tags, stop words and single characters
+func Tokenize(text string) []string {
+	terms := []string{}
+
+	inTag := false
+	word := strings.Builder{}
+	flush := func() {
+		term := word.String()
+		word.Reset()
+		if len(term) < 2 || stopWords[term] {
+			return
+		}
+		terms = append(terms, term)
+	}
+
+	for _, r := range text {
+		switch {
+		case r == '<':
+			flush()
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+		case inTag:
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return terms
+}
+
+// TextQuery is a parsed full-text query. All of its parts
+// must match for a package to be found
+type TextQuery struct {
+	// Terms must be present as they are
+	Terms []string
+	// Prefixes must be a prefix of some term
+	Prefixes []string
+	// Phrases must be present as consecutive terms
+	Phrases [][]string
+}
+
+// ParseTextQuery parses queries like
+//
+//	pdf view* "document viewer"
+//
+// where `view*` matches any word starting with "view" and quoted
+// words must appear next to each other
+func ParseTextQuery(query string) TextQuery {
+	q := TextQuery{}
+
+	for i, part := range strings.Split(query, `"`) {
+		// Every odd part is inside quotes
+		if i%2 == 1 {
+			phrase := Tokenize(part)
+			switch len(phrase) {
+			case 0:
+			case 1:
+				q.Terms = append(q.Terms, phrase[0])
+			default:
+				q.Phrases = append(q.Phrases, phrase)
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(part) {
+			prefix, isPrefix := strings.CutSuffix(word, "*")
+			terms := Tokenize(prefix)
+			if len(terms) == 0 {
+				continue
+			}
+
+			if isPrefix {
+				q.Terms = append(q.Terms, terms[:len(terms)-1]...)
+				q.Prefixes = append(q.Prefixes, terms[len(terms)-1])
+			} else {
+				q.Terms = append(q.Terms, terms...)
+			}
+		}
+	}
+
+	return q
+}
+
+func (q TextQuery) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Prefixes) == 0 && len(q.Phrases) == 0
+}
+
+// noTextIndexError tells how to get the full-text index. The generations
+// stored only in badger are from before the lookup files and need a
+// rebuild, as updating them again only happens with new releases
+func noTextIndexError(genDir, index string) error {
+	_, badgerErr := os.Stat(filepath.Join(genDir, badgerDir))
+	_, lookupErr := os.Stat(filepath.Join(genDir, lookupFile))
+	if badgerErr == nil && lookupErr != nil {
+		return fmt.Errorf(
+			"%w: %s is stored in badger only, rebuild it with `nix-search-tv update --force`",
+			ErrNoTextIndex, index,
+		)
+	}
+
+	return fmt.Errorf("%w: %s", ErrNoTextIndex, index)
+}
+
+// SearchText returns names of the packages of the index matching the query
+func SearchText(cacheDir, index string, query TextQuery) ([]string, error) {
+	genDir := dataDir(filepath.Join(cacheDir, index))
+
+	termsPath := filepath.Join(genDir, termsFile)
+	if _, err := os.Stat(termsPath); err != nil {
+		return nil, noTextIndexError(genDir, index)
+	}
+	terms := NewFileStore(termsPath)
+	defer terms.Close()
+
+	lookup, err := openCurrentStore(cacheDir, index)
+	if err != nil {
+		return nil, err
+	}
+	defer lookup.Close()
+
+	pkgs, ok := lookup.(*FileStore)
+	if !ok {
+		return nil, noTextIndexError(genDir, index)
+	}
+
+	// Every part of the query narrows down the candidates
+	var candidates []uint32
+	narrow := func(ords []uint32) {
+		if candidates == nil {
+			candidates = ords
+			return
+		}
+		candidates = intersect(candidates, ords)
+	}
+
+	exact := slices.Concat(query.Terms, slices.Concat(query.Phrases...))
+	for _, term := range exact {
+		content, err := terms.Load(term)
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("load term %q: %w", term, err)
+		}
+		narrow(decodePostings(content))
+	}
+
+	for _, prefix := range query.Prefixes {
+		ords := []uint32{}
+		err := terms.Scan(prefix, func(_ string, content []byte) error {
+			ords = append(ords, decodePostings(content)...)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan prefix %q: %w", prefix, err)
+		}
+		// A package has many of the terms of a short prefix,
+		// so merge them all at once rather than term by term
+		slices.Sort(ords)
+		narrow(slices.Compact(ords))
+	}
+
+	if err := pkgs.open(); err != nil {
+		return nil, err
+	}
+
+	found := []string{}
+	for _, ord := range candidates {
+		entry, err := pkgs.entry(uint64(ord))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(query.Phrases) > 0 {
+			content, err := pkgs.content(entry)
+			if err != nil {
+				return nil, err
+			}
+			if !containsPhrases(Tokenize(packageText(content)), query.Phrases) {
+				continue
+			}
+		}
+
+		found = append(found, entry.key)
+	}
+
+	return found, nil
+}
+
+func decodePostings(content []byte) []uint32 {
+	ords := []uint32{}
+
+	prev := uint64(0)
+	for len(content) > 0 {
+		delta, n := binary.Uvarint(content)
+		if n <= 0 {
+			break
+		}
+		content = content[n:]
+
+		prev += delta
+		ords = append(ords, uint32(prev))
+	}
+
+	return ords
+}
+
+// intersect returns the ordinals present in both sorted lists
+func intersect(a, b []uint32) []uint32 {
+	out := []uint32{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+func containsPhrases(terms []string, phrases [][]string) bool {
+	for _, phrase := range phrases {
+		found := false
+		for i := 0; i+len(phrase) <= len(terms); i++ {
+			if slices.Equal(terms[i:i+len(phrase)], phrase) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,71 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestTokenize(t *testing.T) {
+	terms := Tokenize("<p>Whether to enable <code>Zathura</code>, a PDF-viewer.</p>")
+	assert.Equal(t, []string{"whether", "enable", "zathura", "pdf", "viewer"}, terms)
+}
+
+func TestParseTextQuery(t *testing.T) {
+	q := ParseTextQuery(`pdf view* "Document  Viewer" "single"`)
+	assert.Equal(t, TextQuery{
+		Terms:    []string{"pdf", "single"},
+		Prefixes: []string{"view"},
+		Phrases:  [][]string{{"document", "viewer"}},
+	}, q)
+}
+
+func TestSearchText(t *testing.T) {
+	for _, store := range []string{StoreBadger, StoreFile} {
+		t.Run(store, func(t *testing.T) {
+			cacheDir := t.TempDir()
+
+			err := runIndex(context.Background(), cacheDir, Index{
+				Name:  "test",
+				Store: store,
+				Fetcher: &testFetcher{release: "v1", data: `{"packages": {
+					"zathura": {"meta": {"description": "Highly customizable and functional PDF viewer"}},
+					"evince": {"meta": {"description": "GNOME's document viewer"}},
+					"mupdf": {"meta": {"description": "Lightweight PDF, XPS, and E-book viewer and toolkit"}},
+					"services.viewer.enable": {"description": "<p>Whether to enable the viewing service.</p>", "type": "boolean"},
+					"broken": "not an object"
+				}}`},
+			})
+			assert.NoError(t, err)
+
+			search := func(query string) []string {
+				found, err := SearchText(cacheDir, "test", ParseTextQuery(query))
+				assert.NoError(t, err)
+				return found
+			}
+
+			assert.Equal(t, []string{"mupdf", "zathura"}, search("pdf viewer"))
+			assert.Equal(t, []string{"evince"}, search(`"document viewer"`))
+			assert.Equal(t, []string{}, search(`"viewer pdf"`))
+			assert.Equal(t, []string{"evince", "mupdf", "services.viewer.enable", "zathura"}, search("view*"))
+			assert.Equal(t, []string{"services.viewer.enable"}, search("boolean"))
+			assert.Equal(t, 0, len(search("unknown")))
+		})
+	}
+}
+
+func TestSearchTextWithoutTerms(t *testing.T) {
+	_, err := SearchText(t.TempDir(), "test", ParseTextQuery("pdf"))
+	assert.IsError(t, err, ErrNoTextIndex)
+
+	// An old generation with badger only
+	cacheDir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "test", badgerDir), 0755))
+
+	_, err = SearchText(cacheDir, "test", ParseTextQuery("pdf"))
+	assert.IsError(t, err, ErrNoTextIndex)
+	assert.Contains(t, err.Error(), "update --force")
+}
@@ -0,0 +1,214 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Every indexing run builds a new generation of the index
+// in its own directory:
+//
+//	<index>/
+//	  metadata.json
+//	  current -> generations/<id>
+//	  generations/
+//	    <id>/
+//	      badger/
+//	      lookup.dat
+//	      terms.dat
+//	      cache.txt
+//
+// Once the generation is complete, the `current` symlink is atomically
+// replaced to point to it. Until then, readers keep using the previous
+// generation, and if indexing is interrupted the unfinished generation
+// is never seen by them and removed by the next run.
+//
+// The replaced generation is kept until the next swap, as readers that
+// resolved `current` right before the swap might not have opened it yet.
+// They only read its lookup file, so its badger is moved into the next
+// generation, which saves copying it and keeping it twice.
+const (
+	currentLink    = "current"
+	generationsDir = "generations"
+	badgerDir      = "badger"
+)
+
+// dataDir returns the directory with the current generation of the index.
+//
+// Indexes created before generations were introduced keep their data
+// directly in the index directory, so fallback to it
+func dataDir(indexDir string) string {
+	target, err := os.Readlink(filepath.Join(indexDir, currentLink))
+	if err != nil {
+		return indexDir
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(indexDir, target)
+	}
+
+	return target
+}
+
+// newGeneration creates a directory for the next generation of the index.
+//
+// If the index is stored in badger, the badger data of the current generation
+// is moved into it, so that the indexer only has to write what changed since
+// then. If the new generation is discarded, the next one starts from scratch
+func newGeneration(indexDir, storeKind string) (string, error) {
+	genDir := filepath.Join(
+		indexDir,
+		generationsDir,
+		strconv.FormatInt(time.Now().UnixNano(), 10),
+	)
+	if err := os.MkdirAll(genDir, 0755); err != nil {
+		return "", fmt.Errorf("create generation directory: %w", err)
+	}
+
+	if storeKind != "" && storeKind != StoreBadger {
+		return genDir, nil
+	}
+
+	prevDir := dataDir(indexDir)
+	prevBadger := filepath.Join(prevDir, badgerDir)
+	if _, err := os.Stat(prevBadger); err != nil {
+		return genDir, nil
+	}
+
+	// Without the lookup file, e.g. in the layout before
+	// generations, the readers open the badger itself
+	if _, err := os.Stat(filepath.Join(prevDir, lookupFile)); err == nil {
+		err = os.Rename(prevBadger, filepath.Join(genDir, badgerDir))
+		if err != nil {
+			return "", fmt.Errorf("move previous generation: %w", err)
+		}
+		return genDir, nil
+	}
+
+	err := copyDir(prevBadger, filepath.Join(genDir, badgerDir))
+	if err != nil {
+		return "", fmt.Errorf("copy previous generation: %w", err)
+	}
+
+	return genDir, nil
+}
+
+// swapGeneration makes the given generation the current one. The previous
+// one is kept for the readers still using it, all the older ones are removed
+func swapGeneration(indexDir, genDir string) error {
+	rel, err := filepath.Rel(indexDir, genDir)
+	if err != nil {
+		return fmt.Errorf("relative generation path: %w", err)
+	}
+
+	_, err = os.Lstat(filepath.Join(indexDir, currentLink))
+	hadGeneration := err == nil
+	prevDir := dataDir(indexDir)
+
+	// Renaming a symlink over another one is atomic, while
+	// removing and re-creating it is not
+	tmpLink := filepath.Join(indexDir, currentLink+".tmp")
+	_ = os.Remove(tmpLink)
+	if err := os.Symlink(rel, tmpLink); err != nil {
+		return fmt.Errorf("create symlink: %w", err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(indexDir, currentLink)); err != nil {
+		return fmt.Errorf("replace current generation: %w", err)
+	}
+
+	gens, err := os.ReadDir(filepath.Join(indexDir, generationsDir))
+	if err != nil {
+		return fmt.Errorf("read generations: %w", err)
+	}
+	for _, gen := range gens {
+		path := filepath.Join(indexDir, generationsDir, gen.Name())
+		if path == genDir || path == prevDir {
+			continue
+		}
+		_ = os.RemoveAll(path)
+	}
+
+	// Leftovers of the pre-generations layout. Without a
+	// generation before, they are the previous one
+	if hadGeneration {
+		_ = os.RemoveAll(filepath.Join(indexDir, badgerDir))
+		_ = os.Remove(filepath.Join(indexDir, cacheFile))
+	}
+
+	return nil
+}
+
+// dirSize returns the total size of the files in the directory
+func dirSize(dir string) int64 {
+	size := int64(0)
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
+
+// discardGeneration removes an unfinished generation
+func discardGeneration(genDir string) {
+	_ = os.RemoveAll(genDir)
+}
+
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		// The lock belongs to whoever has the source open
+		if d.Name() == "LOCK" {
+			return nil
+		}
+
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Badger might have removed the file after
+		// compaction while we were walking the directory
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -3,12 +3,17 @@ package indexer
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
 )
 
 type Fetcher interface {
@@ -20,11 +25,155 @@ type Index struct {
 	Name     string
 	Fetcher  Fetcher
 	Metadata IndexMetadata
+
+	// Store is the kind of the store to build the index with.
+	// Empty means badger
+	Store string
+
+	// Force rebuilds the index even if the release has not changed
+	Force bool
+
+	// Offline rebuilds the index from the stored artifact
+	// of the last release instead of downloading it
+	Offline bool
+
+	// Lenient skips the packages that fail to be indexed instead
+	// of failing the index, see jsonstream.Options
+	Lenient bool
+
+	// MaxArtifactSize limits the size of the compressed artifact kept
+	// for offline re-indexing. Zero disables keeping the artifact
+	MaxArtifactSize int64
+
+	// Progress, if set, is called periodically while the index is being
+	// built. It is called from the indexing goroutine, so must be safe
+	// to call concurrently for different indexes
+	Progress func(Progress)
 }
 
 type IndexMetadata struct {
 	LastIndexedAt time.Time `json:"last_indexed_at"`
 	CurrRelease   string    `json:"curr_release"`
+
+	// ReleaseETag is what the ETagFetcher returned along with CurrRelease
+	ReleaseETag string `json:"release_etag,omitempty"`
+
+	// Stats of the last time the index was built. DownloadSize is what
+	// came over HTTP, not the unpacked data, so it's zero for local
+	// files and for indexes rebuilt from the artifact
+	PackageCount  int           `json:"package_count"`
+	IndexDuration time.Duration `json:"index_duration"`
+	DownloadSize  int64         `json:"download_size"`
+	DiskSize      int64         `json:"disk_size"`
+	Fetcher       string        `json:"fetcher"`
+
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorAt   time.Time `json:"last_error_at,omitzero"`
+	LastSuccessAt time.Time `json:"last_success_at,omitzero"`
+
+	// FailedAttempts is the number of failures in a row. Until
+	// NextRetryAt, the index is not considered for indexing
+	FailedAttempts int       `json:"failed_attempts,omitempty"`
+	NextRetryAt    time.Time `json:"next_retry_at,omitzero"`
+
+	// ArtifactRelease is the release the stored artifact is of
+	ArtifactRelease string `json:"artifact_release,omitempty"`
+	ArtifactSize    int64  `json:"artifact_size,omitempty"`
+
+	// Versions of the formats the index was built with. See StorageVersion
+	// and SchemaFetcher. The artifact has its own schema version, as it
+	// might be of an older release than the index
+	StorageVersion        int `json:"storage_version,omitempty"`
+	SchemaVersion         int `json:"schema_version,omitempty"`
+	ArtifactSchemaVersion int `json:"artifact_schema_version,omitempty"`
+
+	// SkippedCount is the number of packages the lenient parsing skipped
+	// the last time. Skipped are the first of them with the reasons
+	SkippedCount int      `json:"skipped_count,omitempty"`
+	Skipped      []string `json:"skipped,omitempty"`
+}
+
+// maxSkipped is how many of the skipped packages are
+// listed in the metadata, so that it stays small
+const maxSkipped = 20
+
+// StorageVersion is the version of how the indexer stores the packages
+// on disk, e.g. the lookup and terms files. Bump it once the format
+// changes, and the indexes will be rebuilt from their artifacts,
+// or downloaded again if they have none.
+//
+// Versions:
+//
+//	0: a single badger directory, recorded for the indexes
+//	   built before the versions were
+//	1: generations with the lookup and terms files
+const StorageVersion = 1
+
+// SchemaFetcher is implemented by fetchers that shape the packages
+// themselves, e.g. by converting HTML pages into JSON. They bump the
+// version once the shape changes, and the indexes built by their
+// previous versions are downloaded and indexed again
+type SchemaFetcher interface {
+	SchemaVersion() int
+}
+
+func schemaVersion(fetcher Fetcher) int {
+	if fetcher, ok := fetcher.(SchemaFetcher); ok {
+		return fetcher.SchemaVersion()
+	}
+	return 0
+}
+
+// Outdated reports whether the index was built with
+// formats of another version than the current ones
+func Outdated(index Index) bool {
+	return outdated(index.Metadata, index.Fetcher)
+}
+
+func outdated(md IndexMetadata, fetcher Fetcher) bool {
+	if md.CurrRelease == "" {
+		return false
+	}
+
+	return md.StorageVersion != StorageVersion ||
+		md.SchemaVersion != schemaVersion(fetcher)
+}
+
+// The delay before the next attempt doubles after every
+// failure in a row, starting from retryBackoffBase
+const (
+	retryBackoffBase = time.Minute
+	retryBackoffMax  = 6 * time.Hour
+)
+
+func retryBackoff(attempts int) time.Duration {
+	backoff := retryBackoffBase
+	for range attempts - 1 {
+		backoff *= 2
+		if backoff >= retryBackoffMax {
+			return retryBackoffMax
+		}
+	}
+
+	return backoff
+}
+
+var ErrNotIndexed = errors.New("index is not indexed yet")
+
+// RetryLaterError is returned by fetchers that know when the next
+// attempt can succeed, e.g. once an API rate limit resets. The index
+// is not retried until then, instead of after the usual backoff
+type RetryLaterError struct {
+	Err error
+	At  time.Time
+}
+
+func (e *RetryLaterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryLaterError) Unwrap() error {
+	return e.Err
 }
 
 type IndexingResult struct {
@@ -72,56 +221,329 @@ func runIndex(
 	index Index,
 ) error {
 	indexDir := filepath.Join(cacheDir, index.Name)
-	latest, err := index.Fetcher.GetLatestRelease(ctx, index.Metadata)
+
+	unlock, err := lockIndex(ctx, indexDir)
+	if err != nil {
+		return fmt.Errorf("lock index: %w", err)
+	}
+	defer unlock()
+
+	// Another process might have indexed it while we were waiting
+	// for the lock or right before we got it. Reuse its result then
+	md, err := GetIndexMetadata(cacheDir, index.Name)
+	if err != nil {
+		return fmt.Errorf("get metadata: %w", err)
+	}
+	if !index.Force && md.LastIndexedAt.After(index.Metadata.LastIndexedAt) {
+		return nil
+	}
+	if !index.Force && md.LastErrorAt.After(index.Metadata.LastErrorAt) {
+		return fmt.Errorf("failed in another process: %s", md.LastError)
+	}
+
+	prog := startProgress(indexDir, index.Name, index.Progress)
+	defer prog.finish()
+
+	err = indexRelease(ctx, indexDir, index, &md, prog)
+	// Interrupted indexing is not a failure of the index
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	if err != nil {
+		md.LastError = err.Error()
+		md.LastErrorAt = time.Now()
+		// The backoff is for the fetcher, which an offline
+		// rebuild does not reach, e.g. without an artifact
+		if !index.Offline {
+			md.FailedAttempts++
+			md.NextRetryAt = md.LastErrorAt.Add(retryBackoff(md.FailedAttempts))
+			// The fetcher knows better when it makes sense to try again
+			if retry := (*RetryLaterError)(nil); errors.As(err, &retry) && retry.At.After(md.LastErrorAt) {
+				md.NextRetryAt = retry.At
+			}
+		}
+		_ = setIndexMetadata(indexDir, md)
+		return err
+	}
+
+	md.LastIndexedAt = time.Now()
+	md.LastSuccessAt = md.LastIndexedAt
+	md.FailedAttempts = 0
+	md.NextRetryAt = time.Time{}
+	_ = setIndexMetadata(indexDir, md)
+
+	return nil
+}
+
+// indexRelease indexes the latest release if it differs from the
+// current one and records the stats of the indexing into md
+func indexRelease(
+	ctx context.Context,
+	indexDir string,
+	index Index,
+	md *IndexMetadata,
+	prog *progress,
+) error {
+	if index.Offline {
+		return reindexArtifact(indexDir, index, md, prog)
+	}
+
+	// Only the way of storing changed, the artifact is
+	// still good to rebuild the index from
+	schema := schemaVersion(index.Fetcher)
+	oldFormat := outdated(*md, index.Fetcher)
+	if oldFormat && md.ArtifactRelease != "" && md.ArtifactSchemaVersion == schema {
+		// Download it again if the artifact is broken
+		if reindexArtifact(indexDir, index, md, prog) == nil {
+			return nil
+		}
+	}
+
+	latest, etag, err := latestRelease(ctx, index)
 	if err != nil {
 		return fmt.Errorf("get latest release: %w", err)
 	}
-	if latest == index.Metadata.CurrRelease {
-		_ = setIndexMetadata(indexDir, IndexMetadata{
-			LastIndexedAt: time.Now(),
-			CurrRelease:   latest,
-		})
+	if !index.Force && !oldFormat && latest == index.Metadata.CurrRelease {
+		md.CurrRelease, md.ReleaseETag = latest, etag
 		return nil
 	}
 
-	pkgs, err := index.Fetcher.DownloadRelease(ctx, latest)
+	prog.setPhase(PhaseDownloading)
+	download, err := index.Fetcher.DownloadRelease(
+		httpclient.WithBytesCounter(ctx, &prog.downloaded),
+		latest,
+	)
 	if err != nil {
 		return fmt.Errorf("download latest release: %w", err)
 	}
-	defer pkgs.Close()
+	defer download.Close()
+
+	var rd io.Reader = download
+	var artifact *artifactWriter
+	if index.MaxArtifactSize > 0 {
+		artifact = newArtifactWriter(indexDir, index.MaxArtifactSize)
+		defer artifact.discard()
+		rd = io.TeeReader(download, artifact)
+	}
 
-	cache, err := CacheWriter(indexDir)
+	err = buildGeneration(indexDir, index, latest, schema, rd, md, prog)
 	if err != nil {
-		return fmt.Errorf("open cache write: %w", err)
+		return err
 	}
-	defer cache.Close()
+	// Only now, or the next check could tell that nothing
+	// changed since the release that failed to be indexed
+	md.ReleaseETag = etag
+
+	if artifact != nil {
+		md.ArtifactRelease, md.ArtifactSize = "", 0
+		if artifact.commit() == nil {
+			md.ArtifactRelease, md.ArtifactSize = latest, artifact.size
+			md.ArtifactSchemaVersion = schema
+		}
+	}
+
+	return nil
+}
+
+// reindexArtifact rebuilds the index from the stored artifact
+func reindexArtifact(indexDir string, index Index, md *IndexMetadata, prog *progress) error {
+	if md.ArtifactRelease == "" {
+		return ErrNoArtifact
+	}
+
+	prog.setPhase(PhaseDownloading)
+	artifact, err := openArtifact(indexDir)
+	if err != nil {
+		return fmt.Errorf("open artifact: %w", err)
+	}
+	defer artifact.Close()
 
-	badgerDir := filepath.Join(indexDir, "badger")
-	indexer, err := NewBadger(BadgerConfig{
-		Dir: badgerDir,
-	})
+	return buildGeneration(indexDir, index, md.ArtifactRelease, md.ArtifactSchemaVersion, artifact, md, prog)
+}
+
+// buildGeneration indexes the packages of the release into a new
+// generation and makes it the current one
+func buildGeneration(
+	indexDir string,
+	index Index,
+	release string,
+	schema int,
+	download io.Reader,
+	md *IndexMetadata,
+	prog *progress,
+) error {
+	pkgs := &countingReader{rd: download, n: &prog.bytes}
+
+	genDir, err := newGeneration(indexDir, index.Store)
 	if err != nil {
-		return fmt.Errorf("open indexer: %w", err)
+		return fmt.Errorf("create new generation: %w", err)
 	}
-	defer indexer.Close()
 
-	err = indexer.Index(pkgs, cache)
+	count, skipped, err := indexGeneration(genDir, index.Store, parseOptions(index), pkgs, prog)
 	if err != nil {
-		return fmt.Errorf("index packages: %w", err)
+		discardGeneration(genDir)
+		return err
 	}
+	// Read whatever is left after the packages, so that
+	// the artifact gets the download in full
+	_, _ = io.Copy(io.Discard, pkgs)
 
-	_ = setIndexMetadata(indexDir, IndexMetadata{
-		LastIndexedAt: time.Now(),
-		CurrRelease:   latest,
-	})
+	err = swapGeneration(indexDir, genDir)
+	if err != nil {
+		discardGeneration(genDir)
+		return fmt.Errorf("swap generations: %w", err)
+	}
+
+	md.CurrRelease = release
+	md.StorageVersion = StorageVersion
+	md.SchemaVersion = schema
+	md.PackageCount = count
+	md.SkippedCount = skipped.count
+	md.Skipped = skipped.first
+	md.IndexDuration = time.Since(prog.start)
+	md.DownloadSize = prog.downloaded.Load()
+	md.DiskSize = dirSize(genDir)
+	md.Fetcher = strings.TrimPrefix(fmt.Sprintf("%T", index.Fetcher), "*")
 
 	return nil
 }
 
+// skippedPackages collects the packages skipped by the lenient parsing
+type skippedPackages struct {
+	count int
+	first []string
+}
+
+func (s *skippedPackages) add(name string, err error) {
+	s.count++
+	if len(s.first) < maxSkipped {
+		s.first = append(s.first, fmt.Sprintf("%s: %s", name, err))
+	}
+}
+
+// indexGeneration builds the generation from the packages and returns
+// the number of the indexed packages and the skipped ones
+func indexGeneration(
+	genDir string,
+	storeKind string,
+	opts jsonstream.Options,
+	pkgs io.Reader,
+	prog *progress,
+) (int, skippedPackages, error) {
+	skipped := skippedPackages{}
+
+	cache, err := CacheWriter(genDir)
+	if err != nil {
+		return 0, skipped, fmt.Errorf("open cache write: %w", err)
+	}
+	defer cache.Close()
+
+	store, err := OpenStore(storeKind, genDir)
+	if err != nil {
+		// The copy of the previous generation might be broken,
+		// e.g. if it was modified while being copied. Not a big deal,
+		// just index from scratch
+		_ = os.RemoveAll(filepath.Join(genDir, badgerDir))
+		store, err = OpenStore(storeKind, genDir)
+	}
+	if err != nil {
+		return 0, skipped, fmt.Errorf("open store: %w", err)
+	}
+	defer store.Close()
+
+	// The terms are collected along the way, so
+	// that the packages are not decoded again
+	terms := newTermsCollector()
+
+	prog.setPhase(PhaseParsing)
+	opts.Skip = skipped.add
+	opts.Handled = terms.add
+	err = store.Index(pkgs, opts, &lineCounter{wr: cache, n: &prog.packages})
+	if err != nil {
+		return 0, skipped, fmt.Errorf("index packages: %w", err)
+	}
+
+	prog.setPhase(PhaseWriting)
+
+	// The file store is a lookup file on its own
+	lookup, ok := store.(*FileStore)
+	if !ok {
+		err = WriteLookupFile(store, filepath.Join(genDir, lookupFile))
+		if err != nil {
+			return 0, skipped, fmt.Errorf("write lookup file: %w", err)
+		}
+
+		lookup = NewFileStore(filepath.Join(genDir, lookupFile))
+		defer lookup.Close()
+	}
+
+	count, err := lookup.Len()
+	if err != nil {
+		return 0, skipped, fmt.Errorf("count packages: %w", err)
+	}
+
+	err = terms.write(filepath.Join(genDir, termsFile), count)
+	if err != nil {
+		return 0, skipped, fmt.Errorf("write terms file: %w", err)
+	}
+
+	return count, skipped, nil
+}
+
+// ETagFetcher is implemented by fetchers that check for new releases with
+// conditional requests. The returned ETag is kept in the metadata once the
+// release is indexed, and passed back to the next check
+type ETagFetcher interface {
+	GetLatestReleaseETag(context.Context, IndexMetadata) (release, etag string, err error)
+}
+
+func latestRelease(ctx context.Context, index Index) (string, string, error) {
+	if fetcher, ok := index.Fetcher.(ETagFetcher); ok {
+		return fetcher.GetLatestReleaseETag(ctx, index.Metadata)
+	}
+	release, err := index.Fetcher.GetLatestRelease(ctx, index.Metadata)
+	return release, "", err
+}
+
+// ParseFetcher is implemented by fetchers whose packages are
+// not an object in the "packages" field, see jsonstream.Options
+type ParseFetcher interface {
+	ParseOptions() jsonstream.Options
+}
+
+func parseOptions(index Index) jsonstream.Options {
+	opts := jsonstream.Options{}
+	if fetcher, ok := index.Fetcher.(ParseFetcher); ok {
+		opts = fetcher.ParseOptions()
+	}
+	opts.Lenient = opts.Lenient || index.Lenient
+
+	return opts
+}
+
 type OptionFileFetcher interface {
 	Path() string
 }
 
+// ChannelFetcher is implemented by fetchers of a channel,
+// whose releases all start with the same prefix
+type ChannelFetcher interface {
+	ReleasePrefix() string
+}
+
+// ChannelChanged reports whether the index was indexed from
+// another channel than its fetcher is configured with now
+func ChannelChanged(index Index) bool {
+	fetcher, ok := index.Fetcher.(ChannelFetcher)
+	if !ok || index.Metadata.CurrRelease == "" {
+		return false
+	}
+
+	release, ok := strings.CutPrefix(index.Metadata.CurrRelease, fetcher.ReleasePrefix())
+	// nixpkgs/ is a prefix of nixpkgs/25.05-darwin/ too
+	return !ok || strings.Contains(release, "/")
+}
+
 func NeedIndexing(
 	cacheDir string,
 	updateInterval time.Duration,
@@ -130,6 +552,16 @@ func NeedIndexing(
 	needIndex := []Index{}
 
 	for _, index := range indexes {
+		// Do not retry failed indexes on every run, e.g. when offline
+		if time.Now().Before(index.Metadata.NextRetryAt) {
+			continue
+		}
+
+		if Outdated(index) {
+			needIndex = append(needIndex, index)
+			continue
+		}
+
 		if file, ok := index.Fetcher.(OptionFileFetcher); ok {
 			path := file.Path()
 			if path != index.Metadata.CurrRelease {
@@ -139,6 +571,11 @@ func NeedIndexing(
 			continue
 		}
 
+		if ChannelChanged(index) {
+			needIndex = append(needIndex, index)
+			continue
+		}
+
 		if time.Since(index.Metadata.LastIndexedAt) > time.Duration(updateInterval) {
 			needIndex = append(needIndex, index)
 		}
@@ -148,7 +585,7 @@ func NeedIndexing(
 }
 
 func OpenKeysReader(cacheDir, index string) (io.ReadCloser, error) {
-	indexDir := filepath.Join(cacheDir, index)
+	indexDir := dataDir(filepath.Join(cacheDir, index))
 	path, err := initFile(indexDir, cacheFile, nil)
 	if err != nil {
 		return nil, fmt.Errorf("init cache file: %w", err)
@@ -158,19 +595,55 @@ func OpenKeysReader(cacheDir, index string) (io.ReadCloser, error) {
 }
 
 func LoadKey(cacheDir, index, key string) (json.RawMessage, error) {
-	badgerDir := filepath.Join(cacheDir, index, "badger")
-	indexer, err := NewBadger(BadgerConfig{
-		Dir: badgerDir,
-	})
+	store, err := openCurrentStore(cacheDir, index)
 	if err != nil {
-		return nil, fmt.Errorf("open indexer: %w", err)
+		return nil, err
 	}
-	defer indexer.Close()
+	defer store.Close()
 
-	data, err := indexer.Load(key)
+	data, err := store.Load(key)
 	if err != nil {
 		return nil, fmt.Errorf("load key: %w", err)
 	}
 
 	return data, nil
 }
+
+// ScanIndex calls fn for every package of the index whose
+// name starts with prefix
+func ScanIndex(cacheDir, index, prefix string, fn func(key string, content []byte) error) error {
+	store, err := openCurrentStore(cacheDir, index)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.Scan(prefix, fn)
+}
+
+// openCurrentStore opens the current generation of the index for reading.
+//
+// Opening badger is relatively slow and takes a directory lock, which is
+// a problem when tv runs several previews at the same time. So, if there
+// is a lookup file, read from it instead
+func openCurrentStore(cacheDir, index string) (Store, error) {
+	genDir := dataDir(filepath.Join(cacheDir, index))
+
+	for _, file := range []string{storeFile, lookupFile} {
+		path := filepath.Join(genDir, file)
+		if _, err := os.Stat(path); err == nil {
+			return NewFileStore(path), nil
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(genDir, badgerDir)); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotIndexed, index)
+	}
+
+	store, err := OpenStore(StoreBadger, genDir)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	return store, nil
+}
@@ -0,0 +1,674 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
+	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/andybalholm/brotli"
+)
+
+type testFetcher struct {
+	release string
+	data    string
+	err     error
+}
+
+func (f *testFetcher) GetLatestRelease(context.Context, IndexMetadata) (string, error) {
+	return f.release, nil
+}
+
+func (f *testFetcher) DownloadRelease(context.Context, string) (io.ReadCloser, error) {
+	return io.NopCloser(&failingReader{
+		rd:  strings.NewReader(f.data),
+		err: f.err,
+	}), nil
+}
+
+// failingReader returns err once the underlying reader is exhausted,
+// simulating a connection dropped in the middle of a download
+type failingReader struct {
+	rd  io.Reader
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	if err == io.EOF && r.err != nil {
+		return n, r.err
+	}
+	return n, err
+}
+
+func TestRunIndexGenerations(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	run := func(fetcher *testFetcher) error {
+		md, err := GetIndexMetadata(cacheDir, "test")
+		assert.NoError(t, err)
+
+		return runIndex(context.Background(), cacheDir, Index{
+			Name:     "test",
+			Fetcher:  fetcher,
+			Metadata: md,
+		})
+	}
+
+	err := run(&testFetcher{
+		release: "v1",
+		data:    `{"packages": {"pkg": {"v": 1}}}`,
+	})
+	assert.NoError(t, err)
+
+	err = run(&testFetcher{
+		release: "v2",
+		data:    `{"packages": {"pkg": {"v": 2}}}`,
+	})
+	assert.NoError(t, err)
+
+	// The current one and the previous one
+	gens, err := os.ReadDir(filepath.Join(cacheDir, "test", generationsDir))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(gens))
+
+	// The badger is only in the current one, and readers
+	// of the previous one have the lookup file
+	badgers := 0
+	for _, gen := range gens {
+		genDir := filepath.Join(cacheDir, "test", generationsDir, gen.Name())
+		if _, err := os.Stat(filepath.Join(genDir, badgerDir)); err == nil {
+			badgers++
+		}
+		_, err := os.Stat(filepath.Join(genDir, lookupFile))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, badgers)
+
+	pkg, err := LoadKey(cacheDir, "test", "pkg")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"v": 2}`, string(pkg))
+
+	t.Run("interrupted indexing keeps the previous generation", func(t *testing.T) {
+		err := run(&testFetcher{
+			release: "v3",
+			data:    `{"packages": {"pkg": {"v": 3}`,
+			err:     errors.New("connection reset"),
+		})
+		assert.Error(t, err)
+
+		pkg, err := LoadKey(cacheDir, "test", "pkg")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"v": 2}`, string(pkg))
+
+		md, err := GetIndexMetadata(cacheDir, "test")
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", md.CurrRelease)
+		assert.Equal(t, 1, md.PackageCount)
+		assert.Contains(t, md.LastError, "connection reset")
+		assert.True(t, md.LastErrorAt.After(md.LastSuccessAt))
+
+		gens, err := os.ReadDir(filepath.Join(cacheDir, "test", generationsDir))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(gens))
+	})
+
+	t.Run("reader of the replaced generation", func(t *testing.T) {
+		// The reader has resolved the current generation,
+		// but opens it only after the swap
+		genDir := dataDir(filepath.Join(cacheDir, "test"))
+
+		err := run(&testFetcher{
+			release: "v4",
+			data:    `{"packages": {"pkg": {"v": 4}}}`,
+		})
+		assert.NoError(t, err)
+
+		pkg, err := NewFileStore(filepath.Join(genDir, lookupFile)).Load("pkg")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"v": 2}`, string(pkg))
+
+		// Older generations are removed on the next swap
+		err = run(&testFetcher{
+			release: "v5",
+			data:    `{"packages": {"pkg": {"v": 5}}}`,
+		})
+		assert.NoError(t, err)
+
+		_, err = os.Stat(genDir)
+		assert.IsError(t, err, os.ErrNotExist)
+
+		gens, err := os.ReadDir(filepath.Join(cacheDir, "test", generationsDir))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(gens))
+	})
+}
+
+type retryLaterFetcher struct {
+	at time.Time
+}
+
+func (f *retryLaterFetcher) GetLatestRelease(context.Context, IndexMetadata) (string, error) {
+	return "", &RetryLaterError{Err: errors.New("rate limited"), At: f.at}
+}
+
+func (f *retryLaterFetcher) DownloadRelease(context.Context, string) (io.ReadCloser, error) {
+	return nil, errors.New("unexpected download")
+}
+
+func TestRunIndexRetryLater(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	err := runIndex(context.Background(), cacheDir, Index{
+		Name:    "test",
+		Fetcher: &testFetcher{release: "v1", data: `{"packages": {"pkg": {}}}`},
+	})
+	assert.NoError(t, err)
+
+	md, err := GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	indexedAt := md.LastIndexedAt
+
+	// Far later than the backoff after the first failure
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+	err = runIndex(context.Background(), cacheDir, Index{
+		Name:     "test",
+		Fetcher:  &retryLaterFetcher{at: at},
+		Metadata: md,
+	})
+	assert.Error(t, err)
+
+	md, err = GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	assert.True(t, md.NextRetryAt.Equal(at), "next retry at %s", md.NextRetryAt)
+	assert.True(t, md.LastIndexedAt.Equal(indexedAt))
+	assert.Equal(t, "v1", md.CurrRelease)
+	assert.Contains(t, md.LastError, "rate limited")
+}
+
+type etagFetcher struct {
+	testFetcher
+	etag string
+}
+
+func (f *etagFetcher) GetLatestReleaseETag(context.Context, IndexMetadata) (string, string, error) {
+	return f.release, f.etag, nil
+}
+
+func TestRunIndexReleaseETag(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	err := runIndex(context.Background(), cacheDir, Index{
+		Name: "test",
+		Fetcher: &etagFetcher{
+			testFetcher: testFetcher{release: "v1", data: `{"packages": {"pkg": {}}}`},
+			etag:        "e1",
+		},
+	})
+	assert.NoError(t, err)
+
+	md, err := GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", md.CurrRelease)
+	assert.Equal(t, "e1", md.ReleaseETag)
+
+	// A release that fails to be indexed must not be
+	// skipped by the next check because of its ETag
+	err = runIndex(context.Background(), cacheDir, Index{
+		Name: "test",
+		Fetcher: &etagFetcher{
+			testFetcher: testFetcher{release: "v2", data: `{"packages": {`},
+			etag:        "e2",
+		},
+		Metadata: md,
+	})
+	assert.Error(t, err)
+
+	md, err = GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", md.CurrRelease)
+	assert.Equal(t, "e1", md.ReleaseETag)
+
+	// The same release with a new ETag
+	err = runIndex(context.Background(), cacheDir, Index{
+		Name: "test",
+		Fetcher: &etagFetcher{
+			testFetcher: testFetcher{release: "v1"},
+			etag:        "e3",
+		},
+		Metadata: md,
+	})
+	assert.NoError(t, err)
+
+	md, err = GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", md.CurrRelease)
+	assert.Equal(t, "e3", md.ReleaseETag)
+}
+
+func TestLoadKeyWithoutBadger(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	err := runIndex(context.Background(), cacheDir, Index{
+		Name:    "test",
+		Fetcher: &testFetcher{release: "v1", data: `{"packages": {"pkg": {"v": 1}}}`},
+	})
+	assert.NoError(t, err)
+
+	genDir := dataDir(filepath.Join(cacheDir, "test"))
+	_, err = os.Stat(filepath.Join(genDir, lookupFile))
+	assert.NoError(t, err)
+
+	// Keep badger open, so that its directory is locked
+	bdg, err := NewBadger(BadgerConfig{Dir: filepath.Join(genDir, badgerDir)})
+	assert.NoError(t, err)
+	defer bdg.Close()
+
+	pkg, err := LoadKey(cacheDir, "test", "pkg")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"v": 1}`, string(pkg))
+}
+
+func TestRunIndexProgress(t *testing.T) {
+	cacheDir := t.TempDir()
+	data := `{"packages": {"a": {}, "b": {}}}`
+
+	reports := []Progress{}
+	err := runIndex(context.Background(), cacheDir, Index{
+		Name:    "test",
+		Fetcher: &testFetcher{release: "v1", data: data},
+		Progress: func(p Progress) {
+			reports = append(reports, p)
+		},
+	})
+	assert.NoError(t, err)
+
+	phases := []Phase{}
+	for _, report := range reports {
+		if len(phases) == 0 || phases[len(phases)-1] != report.Phase {
+			phases = append(phases, report.Phase)
+		}
+	}
+	assert.Equal(t, []Phase{
+		PhaseChecking,
+		PhaseDownloading,
+		PhaseParsing,
+		PhaseWriting,
+		PhaseDone,
+	}, phases)
+
+	last := reports[len(reports)-1]
+	assert.Equal(t, "test", last.Index)
+	assert.Equal(t, 2, last.Packages)
+	assert.Equal(t, int64(len(data)), last.Bytes)
+
+	// The progress file is only there while indexing
+	running, err := ReadProgress(cacheDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(running))
+}
+
+// brotliFetcher downloads a brotli-compressed release, like the nixpkgs one
+type brotliFetcher struct {
+	client *http.Client
+	url    string
+}
+
+func (f *brotliFetcher) GetLatestRelease(context.Context, IndexMetadata) (string, error) {
+	return "v1", nil
+}
+
+func (f *brotliFetcher) DownloadRelease(ctx context.Context, _ string) (io.ReadCloser, error) {
+	body, err := httpclient.Get(ctx, f.client, f.url)
+	if err != nil {
+		return nil, err
+	}
+
+	return readutil.NewBrotli(body), nil
+}
+
+func TestRunIndexDownloadSize(t *testing.T) {
+	data := `{"packages": {"a": {"description": "` + strings.Repeat("a", 1000) + `"}}}`
+	compressed := bytes.Buffer{}
+	br := brotli.NewWriter(&compressed)
+	_, err := br.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, br.Close())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(compressed.Bytes())
+	}))
+	defer srv.Close()
+
+	client, err := httpclient.New(httpclient.DefaultConfig())
+	assert.NoError(t, err)
+
+	cacheDir := t.TempDir()
+	err = runIndex(context.Background(), cacheDir, Index{
+		Name:    "test",
+		Fetcher: &brotliFetcher{client: client, url: srv.URL},
+	})
+	assert.NoError(t, err)
+
+	// The compressed size, not the size of the JSON
+	md, err := GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(compressed.Len()), md.DownloadSize)
+}
+
+func TestReadProgress(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	indexDir := filepath.Join(cacheDir, "test")
+	assert.NoError(t, os.MkdirAll(indexDir, 0755))
+
+	prog := startProgress(indexDir, "test", nil)
+	prog.setPhase(PhaseParsing)
+	prog.packages.Add(10)
+
+	running, err := ReadProgress(cacheDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(running))
+	assert.Equal(t, PhaseParsing, running[0].Phase)
+
+	prog.finish()
+
+	running, err = ReadProgress(cacheDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(running))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, retryBackoff(1))
+	assert.Equal(t, 2*time.Minute, retryBackoff(2))
+	assert.Equal(t, 8*time.Minute, retryBackoff(4))
+	assert.Equal(t, retryBackoffMax, retryBackoff(100))
+}
+
+func TestNeedIndexingBackoff(t *testing.T) {
+	index := Index{
+		Name:    "test",
+		Fetcher: &testFetcher{},
+		Metadata: IndexMetadata{
+			NextRetryAt: time.Now().Add(time.Minute),
+		},
+	}
+
+	need, err := NeedIndexing(t.TempDir(), time.Hour, []Index{index})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(need))
+
+	index.Metadata.NextRetryAt = time.Now().Add(-time.Minute)
+	need, err = NeedIndexing(t.TempDir(), time.Hour, []Index{index})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(need))
+}
+
+type channelFetcher struct {
+	testFetcher
+	prefix string
+}
+
+func (f *channelFetcher) ReleasePrefix() string {
+	return f.prefix
+}
+
+func TestNeedIndexingChannelChanged(t *testing.T) {
+	index := Index{
+		Name:    "test",
+		Fetcher: &channelFetcher{prefix: "nixpkgs/"},
+		Metadata: IndexMetadata{
+			CurrRelease:    "nixpkgs/nixpkgs-25.11pre1.abc",
+			LastIndexedAt:  time.Now(),
+			StorageVersion: StorageVersion,
+		},
+	}
+
+	need, err := NeedIndexing(t.TempDir(), time.Hour, []Index{index})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(need))
+
+	index.Metadata.CurrRelease = "nixpkgs/25.05-darwin/nixpkgs-darwin-25.05.1.abc"
+	need, err = NeedIndexing(t.TempDir(), time.Hour, []Index{index})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(need))
+	assert.True(t, ChannelChanged(need[0]))
+}
+
+func TestRunIndexOffline(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	run := func(index Index) error {
+		md, err := GetIndexMetadata(cacheDir, "test")
+		assert.NoError(t, err)
+
+		index.Name = "test"
+		index.Metadata = md
+		return runIndex(context.Background(), cacheDir, index)
+	}
+
+	err := run(Index{Offline: true})
+	assert.IsError(t, err, ErrNoArtifact)
+
+	md, err := GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	assert.Contains(t, md.LastError, ErrNoArtifact.Error())
+	assert.Equal(t, 0, md.FailedAttempts)
+	assert.True(t, md.NextRetryAt.IsZero())
+
+	err = run(Index{
+		Fetcher: &testFetcher{
+			release: "v1",
+			data:    `{"packages": {"pkg": {"v": 1}}}`,
+		},
+		MaxArtifactSize: 1 << 20,
+	})
+	assert.NoError(t, err)
+
+	md, err = GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", md.ArtifactRelease)
+
+	// Rebuild from scratch, as if the index was corrupted
+	assert.NoError(t, os.RemoveAll(filepath.Join(cacheDir, "test", generationsDir)))
+
+	err = run(Index{Offline: true, Force: true})
+	assert.NoError(t, err)
+
+	pkg, err := LoadKey(cacheDir, "test", "pkg")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"v": 1}`, string(pkg))
+
+	t.Run("too big artifact is not kept", func(t *testing.T) {
+		err := run(Index{
+			Fetcher: &testFetcher{
+				release: "v2",
+				data:    `{"packages": {"pkg": {"v": 2}}}`,
+			},
+			MaxArtifactSize: 1,
+		})
+		assert.NoError(t, err)
+
+		md, err := GetIndexMetadata(cacheDir, "test")
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", md.CurrRelease)
+		assert.Equal(t, "", md.ArtifactRelease)
+
+		_, err = os.Stat(filepath.Join(cacheDir, "test", artifactFile))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+type schemaFetcher struct {
+	testFetcher
+	version int
+}
+
+func (f *schemaFetcher) SchemaVersion() int {
+	return f.version
+}
+
+func TestRunIndexOutdated(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	run := func(fetcher Fetcher) error {
+		md, err := GetIndexMetadata(cacheDir, "test")
+		assert.NoError(t, err)
+
+		return runIndex(context.Background(), cacheDir, Index{
+			Name:            "test",
+			Fetcher:         fetcher,
+			Metadata:        md,
+			MaxArtifactSize: 1 << 20,
+		})
+	}
+	setMetadata := func(fn func(*IndexMetadata)) {
+		md, err := GetIndexMetadata(cacheDir, "test")
+		assert.NoError(t, err)
+		fn(&md)
+		assert.NoError(t, setIndexMetadata(filepath.Join(cacheDir, "test"), md))
+	}
+
+	err := run(&schemaFetcher{
+		testFetcher: testFetcher{release: "v1", data: `{"packages": {"pkg": {"v": 1}}}`},
+		version:     1,
+	})
+	assert.NoError(t, err)
+
+	md, err := GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	assert.False(t, Outdated(Index{Fetcher: &schemaFetcher{version: 1}, Metadata: md}))
+	assert.True(t, Outdated(Index{Fetcher: &schemaFetcher{version: 2}, Metadata: md}))
+
+	t.Run("storage change rebuilds from the artifact", func(t *testing.T) {
+		setMetadata(func(md *IndexMetadata) { md.StorageVersion = StorageVersion - 1 })
+
+		// The same release but other data, so that it's
+		// visible the data was not downloaded again
+		err := run(&schemaFetcher{
+			testFetcher: testFetcher{release: "v1", data: `{"packages": {"pkg": {"v": "downloaded"}}}`},
+			version:     1,
+		})
+		assert.NoError(t, err)
+
+		pkg, err := LoadKey(cacheDir, "test", "pkg")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"v": 1}`, string(pkg))
+
+		md, err := GetIndexMetadata(cacheDir, "test")
+		assert.NoError(t, err)
+		assert.Equal(t, StorageVersion, md.StorageVersion)
+	})
+
+	t.Run("schema change downloads again", func(t *testing.T) {
+		err := run(&schemaFetcher{
+			testFetcher: testFetcher{release: "v1", data: `{"packages": {"pkg": {"v": 2}}}`},
+			version:     2,
+		})
+		assert.NoError(t, err)
+
+		pkg, err := LoadKey(cacheDir, "test", "pkg")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"v": 2}`, string(pkg))
+
+		md, err := GetIndexMetadata(cacheDir, "test")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, md.SchemaVersion)
+		assert.Equal(t, 2, md.ArtifactSchemaVersion)
+	})
+}
+
+func TestRunIndexBaselineLayout(t *testing.T) {
+	cacheDir := t.TempDir()
+	indexDir := filepath.Join(cacheDir, "test")
+
+	// The layout of the indexes built before the generations: a single
+	// badger directory, the keys file and the metadata without versions
+	bdg, err := NewBadger(BadgerConfig{Dir: filepath.Join(indexDir, badgerDir)})
+	assert.NoError(t, err)
+	keys := bytes.Buffer{}
+	err = bdg.Index(strings.NewReader(`{"packages": {"pkg": {"v": "old"}}}`), jsonstream.Options{}, &keys)
+	assert.NoError(t, err)
+	assert.NoError(t, bdg.Close())
+	assert.NoError(t, os.WriteFile(filepath.Join(indexDir, cacheFile), keys.Bytes(), 0666))
+
+	md := fmt.Sprintf(`{"last_indexed_at": %q, "curr_release": "v1"}`, time.Now().Format(time.RFC3339))
+	assert.NoError(t, os.WriteFile(filepath.Join(indexDir, metadataFile), []byte(md), 0666))
+
+	index := Index{
+		Name:    "test",
+		Fetcher: &testFetcher{release: "v1", data: `{"packages": {"pkg": {"v": "new", "description": "A PDF viewer"}}}`},
+	}
+	index.Metadata, err = GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+
+	// Indexed just now and with the same release,
+	// but the index still needs to be rebuilt
+	need, err := NeedIndexing(cacheDir, time.Hour, []Index{index})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(need))
+
+	_, err = SearchText(cacheDir, "test", ParseTextQuery("pdf"))
+	assert.IsError(t, err, ErrNoTextIndex)
+
+	assert.NoError(t, runIndex(context.Background(), cacheDir, need[0]))
+
+	pkg, err := LoadKey(cacheDir, "test", "pkg")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"v": "new", "description": "A PDF viewer"}`, string(pkg))
+
+	found, err := SearchText(cacheDir, "test", ParseTextQuery("pdf"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pkg"}, found)
+
+	index.Metadata, err = GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, StorageVersion, index.Metadata.StorageVersion)
+	assert.False(t, Outdated(index))
+}
+
+func TestRunIndexLenient(t *testing.T) {
+	cacheDir := t.TempDir()
+	fetcher := &testFetcher{
+		release: "v1",
+		data:    `{"packages": {"pkg": {"v": 1}, "dup": {}, "dup": {}, "bad": {"v": 1, "v": 2}}}`,
+	}
+
+	err := runIndex(context.Background(), cacheDir, Index{
+		Name:    "strict",
+		Fetcher: fetcher,
+	})
+	assert.Error(t, err)
+
+	err = runIndex(context.Background(), cacheDir, Index{
+		Name:    "lenient",
+		Fetcher: fetcher,
+		Lenient: true,
+	})
+	assert.NoError(t, err)
+
+	md, err := GetIndexMetadata(cacheDir, "lenient")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, md.PackageCount)
+	assert.Equal(t, 2, md.SkippedCount)
+	assert.Equal(t, []string{
+		"dup: duplicate package name",
+		"bad: invalid package content",
+	}, md.Skipped)
+
+	_, err = LoadKey(cacheDir, "lenient", "bad")
+	assert.Error(t, err)
+}
@@ -1,11 +1,86 @@
 package jsonstream
 
 import (
+	"bytes"
 	"encoding/json/jsontext"
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
+// Formats of the packages
+const (
+	// FormatObject is an object of packages by their names
+	FormatObject = "object"
+
+	// FormatArray is an array of packages, each with its name in KeyField
+	FormatArray = "array"
+
+	// FormatNDJSON is a package per line, each with its name in KeyField.
+	// The whole input is the packages, so Pointer is not used
+	FormatNDJSON = "ndjson"
+)
+
+// DefaultPointer is where the packages are unless Pointer says otherwise
+const DefaultPointer = "/packages"
+
+// RootPointer points to the whole document. It is "" in RFC 6901,
+// but that is the default here, and package sets don't have empty keys
+const RootPointer = "/"
+
+type Options struct {
+	// Pointer is the JSON pointer to the packages,
+	// DefaultPointer if empty. See RootPointer
+	Pointer string
+
+	// Format is how the packages are laid out, FormatObject if empty
+	Format string
+
+	// KeyField is the field with the name of
+	// a package in the array and NDJSON formats
+	KeyField string
+
+	// Lenient skips the packages that can't be indexed instead of failing
+	// the whole parsing: the ones with invalid content, e.g. duplicate
+	// fields or invalid UTF-8, the repeated names and the ones the
+	// callback fails on. A broken JSON syntax still fails it, as
+	// there is no telling where the next package starts
+	Lenient bool
+
+	// Skip is called for every skipped package in the lenient mode
+	Skip func(name string, err error)
+
+	// Handled is called for every package the callback succeeded on.
+	// Like in the callback, the content is only valid until it returns
+	Handled func(name string, content []byte)
+}
+
+var (
+	ErrDuplicateName = errors.New("duplicate package name")
+	ErrNotFound      = errors.New("packages not found")
+)
+
+func (opts Options) Validate() error {
+	if opts.Pointer != "" && !strings.HasPrefix(opts.Pointer, "/") {
+		return fmt.Errorf("invalid JSON pointer %q, it must start with /", opts.Pointer)
+	}
+
+	switch opts.Format {
+	case "", FormatObject:
+		return nil
+	case FormatArray, FormatNDJSON:
+		if opts.KeyField == "" {
+			return fmt.Errorf("%s format requires a key field", opts.Format)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", opts.Format)
+	}
+}
+
 // ParsePackages parses packages json file of the format below
 //
 //	{
@@ -16,47 +91,68 @@ import (
 //	  ...
 //	}
 func ParsePackages(pkgs io.Reader, cb func(name string, content []byte) error) error {
+	return Parse(pkgs, Options{}, cb)
+}
+
+// Parse is ParsePackages for the packages in other places and formats
+func Parse(pkgs io.Reader, opts Options, cb func(name string, content []byte) error) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
 	dec := jsontext.NewDecoder(pkgs)
+	if opts.Lenient {
+		// Checked for every package below instead
+		dec = jsontext.NewDecoder(
+			pkgs,
+			jsontext.AllowDuplicateNames(true),
+			jsontext.AllowInvalidUTF8(true),
+		)
+	}
 
-	// We're here
-	// ↓
-	// { "other": ..., "packages": { "pkg1": {...}, "pkg2": {...} } }
-	t, err := dec.ReadToken()
-	if err != nil {
-		return fmt.Errorf("read opening curly bracket: %w", err)
+	p := &parser{
+		opts: opts,
+		cb:   cb,
+		seen: map[string]struct{}{},
 	}
 
-	for {
-		//   ↓ (1)         ↓ (2)
-		// { "other": ..., "packages": { "pkg1": {...}, "pkg2": {...} } }
-		t, err = dec.ReadToken()
-		if err != nil {
-			return fmt.Errorf("read root key: %w", err)
+	switch opts.Format {
+	case FormatArray:
+		if err := seek(dec, opts.Pointer); err != nil {
+			return err
 		}
+		return p.parseArray(dec)
 
-		if t.Kind() != '"' {
-			return fmt.Errorf("expected root key as string, but got %s", t.Kind())
-		}
-		if t.String() == "packages" {
-			break
-		}
+	case FormatNDJSON:
+		return p.parseNDJSON(dec)
 
-		dec.SkipValue()
+	default:
+		if err := seek(dec, opts.Pointer); err != nil {
+			return err
+		}
+		return p.parseObject(dec)
 	}
+}
+
+type parser struct {
+	opts Options
+	cb   func(name string, content []byte) error
+	seen map[string]struct{}
+}
 
-	//                             ↓ consume the opening '{'
-	// { "other": ..., "packages": { "pkg1": {...}, "pkg2": {...} } }
-	t, err = dec.ReadToken()
+// parseObject parses the packages by their names
+//
+//	{ "pkg1": {...}, "pkg2": {...} }
+func (p *parser) parseObject(dec *jsontext.Decoder) error {
+	t, err := dec.ReadToken()
 	if err != nil {
-		return fmt.Errorf("read opening bracket for 'packages': %w", err)
+		return fmt.Errorf("read opening bracket for packages: %w", err)
+	}
+	if t.Kind() != '{' {
+		return fmt.Errorf("expected packages as object, but got %s", t.Kind())
 	}
-
-	var name string
-	var content []byte
 
 	for {
-		//                               ↓ (1)          ↓ (2)
-		// { "other": ..., "packages": { "pkg1": {...}, "pkg2": {...} } }
 		t, err = dec.ReadToken()
 		if err != nil {
 			return fmt.Errorf("read package name: %w", err)
@@ -64,22 +160,248 @@ func ParsePackages(pkgs io.Reader, cb func(name string, content []byte) error) e
 
 		switch t.Kind() {
 		case '"':
-			name = t.String()
 		case '}':
-			//                                                            ↓
-			// { "other": ..., "packages": { "pkg1": {...}, "pkg2": {...} } }
 			return nil
 		default:
 			return fmt.Errorf("expected package name as string, but got %s", t.Kind())
 		}
+		name := t.String()
 
-		content, err = dec.ReadValue()
+		content, err := dec.ReadValue()
 		if err != nil {
 			return fmt.Errorf("read package content: %w", err)
 		}
 
-		if err = cb(name, content); err != nil {
+		if err := p.handle(name, content, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// parseArray parses the packages with their names in the key field
+//
+//	[ { "name": "pkg1", ... }, { "name": "pkg2", ... } ]
+func (p *parser) parseArray(dec *jsontext.Decoder) error {
+	t, err := dec.ReadToken()
+	if err != nil {
+		return fmt.Errorf("read opening bracket for packages: %w", err)
+	}
+	if t.Kind() != '[' {
+		return fmt.Errorf("expected packages as array, but got %s", t.Kind())
+	}
+
+	for i := 0; dec.PeekKind() != ']'; i++ {
+		content, err := dec.ReadValue()
+		if err != nil {
+			return fmt.Errorf("read package content: %w", err)
+		}
+
+		if err := p.handleKeyed(i, content); err != nil {
+			return err
+		}
+	}
+
+	_, err = dec.ReadToken()
+	return err
+}
+
+// parseNDJSON parses the packages with their names in the key field
+//
+//	{ "name": "pkg1", ... }
+//	{ "name": "pkg2", ... }
+func (p *parser) parseNDJSON(dec *jsontext.Decoder) error {
+	for i := 0; ; i++ {
+		content, err := dec.ReadValue()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read package content: %w", err)
+		}
+
+		if err := p.handleKeyed(i, content); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) handleKeyed(i int, content []byte) error {
+	name, err := keyOf(content, p.opts.KeyField)
+	if err != nil {
+		// There is no name to report, so the number will do
+		name = "#" + strconv.Itoa(i)
+	}
+
+	return p.handle(name, content, err)
+}
+
+func (p *parser) handle(name string, content []byte, err error) error {
+	if !p.opts.Lenient {
+		if err != nil {
+			return fmt.Errorf("package %s: %w", name, err)
+		}
+		// The decoder only catches the duplicates of the object
+		// format, the names from the key field are checked here
+		if _, ok := p.seen[name]; ok {
+			return fmt.Errorf("package %s: %w", name, ErrDuplicateName)
+		}
+		p.seen[name] = struct{}{}
+
+		if err = p.cb(name, content); err != nil {
 			return fmt.Errorf("callback failed: %w", err)
 		}
+		p.handled(name, content)
+		return nil
+	}
+
+	if err != nil {
+		p.skip(name, err)
+		return nil
+	}
+
+	if _, ok := p.seen[name]; ok {
+		p.skip(name, ErrDuplicateName)
+		return nil
+	}
+	p.seen[name] = struct{}{}
+
+	if !utf8.ValidString(name) || !jsontext.Value(content).IsValid() {
+		p.skip(name, errors.New("invalid package content"))
+		return nil
+	}
+	if err = p.cb(name, content); err != nil {
+		p.skip(name, err)
+		return nil
+	}
+	p.handled(name, content)
+
+	return nil
+}
+
+func (p *parser) handled(name string, content []byte) {
+	if p.opts.Handled != nil {
+		p.opts.Handled(name, content)
+	}
+}
+
+func (p *parser) skip(name string, err error) {
+	if p.opts.Skip != nil {
+		p.opts.Skip(name, err)
+	}
+}
+
+// seek moves the decoder right before the value the pointer points to
+func seek(dec *jsontext.Decoder, pointer string) error {
+	if pointer == "" {
+		pointer = DefaultPointer
+	}
+	if pointer == RootPointer {
+		return nil
+	}
+
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	for _, ref := range strings.Split(pointer[1:], "/") {
+		ref = unescape.Replace(ref)
+
+		t, err := dec.ReadToken()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", pointer, err)
+		}
+
+		switch t.Kind() {
+		case '{':
+			err = seekName(dec, ref)
+		case '[':
+			err = seekIndex(dec, ref)
+		default:
+			err = fmt.Errorf("%s is not a container", t.Kind())
+		}
+		if err != nil {
+			return fmt.Errorf("%w at %s: %w", ErrNotFound, pointer, err)
+		}
+	}
+
+	return nil
+}
+
+func seekName(dec *jsontext.Decoder, name string) error {
+	for {
+		t, err := dec.ReadToken()
+		if err != nil {
+			return err
+		}
+		if t.Kind() == '}' {
+			return fmt.Errorf("no %q key", name)
+		}
+		if t.String() == name {
+			return nil
+		}
+
+		if err := dec.SkipValue(); err != nil {
+			return err
+		}
+	}
+}
+
+func seekIndex(dec *jsontext.Decoder, ref string) error {
+	idx, err := strconv.Atoi(ref)
+	if err != nil || idx < 0 {
+		return fmt.Errorf("invalid array index %q", ref)
+	}
+
+	for i := 0; i < idx; i++ {
+		if dec.PeekKind() == ']' {
+			return fmt.Errorf("no %d index", idx)
+		}
+		if err := dec.SkipValue(); err != nil {
+			return err
+		}
+	}
+	if dec.PeekKind() == ']' {
+		return fmt.Errorf("no %d index", idx)
+	}
+
+	return nil
+}
+
+// keyOf returns the value of the string field of the object
+func keyOf(content []byte, field string) (string, error) {
+	dec := jsontext.NewDecoder(
+		bytes.NewReader(content),
+		jsontext.AllowDuplicateNames(true),
+		jsontext.AllowInvalidUTF8(true),
+	)
+
+	t, err := dec.ReadToken()
+	if err != nil {
+		return "", err
+	}
+	if t.Kind() != '{' {
+		return "", fmt.Errorf("expected package as object, but got %s", t.Kind())
+	}
+
+	for {
+		t, err := dec.ReadToken()
+		if err != nil {
+			return "", err
+		}
+		if t.Kind() == '}' {
+			return "", fmt.Errorf("no %q field", field)
+		}
+		if t.String() != field {
+			if err := dec.SkipValue(); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		v, err := dec.ReadToken()
+		if err != nil {
+			return "", err
+		}
+		if v.Kind() != '"' {
+			return "", fmt.Errorf("%q field is %s, not a string", field, v.Kind())
+		}
+		return v.String(), nil
 	}
 }
@@ -3,6 +3,7 @@ package jsonstream
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/alecthomas/assert/v2"
@@ -38,3 +39,156 @@ func TestUnmarshalNixpkgs(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expected, actual)
 }
+
+func TestParseLenient(t *testing.T) {
+	input := bytes.NewBufferString(`
+	{
+	  "packages": {
+	    "pkg1": { "v": 1 },
+	    "pkg1": { "v": "again" },
+	    "pkg2": { "v": 2, "v": 3 },
+	    "pkg3": { "v": "fail" },
+	    "pkg4": { "v": 4 }
+	  }
+	}
+	`)
+
+	parsed := []string{}
+	handled := []string{}
+	skipped := map[string]string{}
+	opts := Options{
+		Lenient: true,
+		Skip: func(name string, err error) {
+			skipped[name] = err.Error()
+		},
+		Handled: func(name string, _ []byte) {
+			handled = append(handled, name)
+		},
+	}
+
+	err := Parse(input, opts, func(k string, v []byte) error {
+		if k == "pkg3" {
+			return errors.New("callback failed")
+		}
+		parsed = append(parsed, k+" "+string(v))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`pkg1 { "v": 1 }`, `pkg4 { "v": 4 }`}, parsed)
+	assert.Equal(t, []string{"pkg1", "pkg4"}, handled)
+	assert.Equal(t, map[string]string{
+		"pkg1": ErrDuplicateName.Error(),
+		"pkg2": "invalid package content",
+		"pkg3": "callback failed",
+	}, skipped)
+
+	// Broken syntax fails even the lenient parsing
+	err = Parse(bytes.NewBufferString(`{"packages": {"pkg1": {"v": }}}`), opts, func(string, []byte) error {
+		return nil
+	})
+	assert.Error(t, err)
+}
+
+func TestParseFormats(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Opts  Options
+		Input string
+	}{
+		{
+			Name:  "object at pointer",
+			Opts:  Options{Pointer: "/data/0/options"},
+			Input: `{"data": [{"options": {"pkg1": {"v": 1}, "pkg2": {"v": 2}}}, {}]}`,
+		},
+		{
+			Name:  "escaped pointer",
+			Opts:  Options{Pointer: "/a~1b/c~0d"},
+			Input: `{"other": {}, "a/b": {"c~d": {"pkg1": {"v": 1}, "pkg2": {"v": 2}}}}`,
+		},
+		{
+			Name:  "array at root",
+			Opts:  Options{Pointer: RootPointer, Format: FormatArray, KeyField: "name"},
+			Input: `[{"v": 1, "name": "pkg1"}, {"name": "pkg2", "v": 2}]`,
+		},
+		{
+			Name:  "ndjson",
+			Opts:  Options{Format: FormatNDJSON, KeyField: "name"},
+			Input: "{\"name\": \"pkg1\", \"v\": 1}\n{\"name\": \"pkg2\", \"v\": 2}\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			parsed := map[string]float64{}
+			err := Parse(bytes.NewBufferString(tc.Input), tc.Opts, func(k string, v []byte) error {
+				pkg := map[string]any{}
+				if err := json.Unmarshal(v, &pkg); err != nil {
+					return err
+				}
+				parsed[k] = pkg["v"].(float64)
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]float64{"pkg1": 1, "pkg2": 2}, parsed)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	noop := func(string, []byte) error { return nil }
+
+	err := Parse(bytes.NewBufferString(`{"data": {}}`), Options{Pointer: "/data/options"}, noop)
+	assert.True(t, errors.Is(err, ErrNotFound), "got %v", err)
+
+	err = Parse(bytes.NewBufferString(`[]`), Options{Format: FormatArray}, noop)
+	assert.EqualError(t, err, "array format requires a key field")
+
+	// Without the key field, the package can only be skipped
+	input := `[{"name": "pkg1"}, {"id": "pkg2"}, {"name": 3}]`
+	opts := Options{Pointer: RootPointer, Format: FormatArray, KeyField: "name"}
+	err = Parse(bytes.NewBufferString(input), opts, noop)
+	assert.Error(t, err)
+
+	skipped := []string{}
+	opts.Lenient = true
+	opts.Skip = func(name string, err error) {
+		skipped = append(skipped, name+": "+err.Error())
+	}
+	err = Parse(bytes.NewBufferString(input), opts, noop)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`#1: no "name" field`,
+		`#2: "name" field is number, not a string`,
+	}, skipped)
+}
+
+func TestParseDuplicateKeys(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Opts  Options
+		Input string
+	}{
+		{
+			Name:  "array",
+			Opts:  Options{Pointer: RootPointer, Format: FormatArray, KeyField: "k"},
+			Input: `[{"k": "a", "v": 1}, {"k": "a", "v": 2}]`,
+		},
+		{
+			Name:  "ndjson",
+			Opts:  Options{Format: FormatNDJSON, KeyField: "k"},
+			Input: "{\"k\": \"a\", \"v\": 1}\n{\"k\": \"a\", \"v\": 2}\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			names := []string{}
+			err := Parse(bytes.NewBufferString(tc.Input), tc.Opts, func(k string, _ []byte) error {
+				names = append(names, k)
+				return nil
+			})
+			assert.True(t, errors.Is(err, ErrDuplicateName), "got %v", err)
+			assert.Equal(t, []string{"a"}, names)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const lockFile = "indexing.lock"
+
+const lockPollInterval = 100 * time.Millisecond
+
+// lockInfo is written into the lock file by its owner. Nothing depends
+// on it, it is only there to tell who holds the lock
+type lockInfo struct {
+	PID       int       `json:"pid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// lockIndex takes an inter-process lock on the index directory.
+//
+// tv and fzf often start several `print` processes at once, and
+// without the lock all of them would download and index the same
+// release into the same directory. If the lock is taken, lockIndex
+// waits until it is released or the context is done.
+//
+// The lock is an OS lock on a file that is never removed. The OS
+// releases it when its owner dies, so there are no stale locks to
+// take over, and taking them over is what makes lock files racy
+func lockIndex(ctx context.Context, indexDir string) (func(), error) {
+	if err := os.MkdirAll(indexDir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(indexDir, lockFile), os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+
+	for {
+		acquired, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("lock file: %w", err)
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	if err := file.Truncate(0); err == nil {
+		_ = json.NewEncoder(file).Encode(lockInfo{
+			PID:       os.Getpid(),
+			CreatedAt: time.Now(),
+		})
+	}
+
+	return func() {
+		_ = unlockFile(file)
+		file.Close()
+	}, nil
+}
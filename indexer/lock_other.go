@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package indexer
+
+import "os"
+
+// There are no file locks here, but also
+// no other processes to share the index with
+func tryLockFile(*os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
@@ -0,0 +1,105 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestLockIndex(t *testing.T) {
+	t.Run("waits for the owner", func(t *testing.T) {
+		dir := t.TempDir()
+
+		unlock, err := lockIndex(context.Background(), dir)
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*lockPollInterval)
+		defer cancel()
+		_, err = lockIndex(ctx, dir)
+		assert.IsError(t, err, context.DeadlineExceeded)
+
+		unlock()
+
+		unlock, err = lockIndex(context.Background(), dir)
+		assert.NoError(t, err)
+		unlock()
+	})
+
+	t.Run("lock file left behind", func(t *testing.T) {
+		dir := t.TempDir()
+
+		// The file of a dead owner is still there, but nobody holds the lock
+		data, err := json.Marshal(lockInfo{PID: 1 << 30, CreatedAt: time.Now()})
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, lockFile), data, 0666))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		unlock, err := lockIndex(ctx, dir)
+		assert.NoError(t, err)
+		unlock()
+	})
+
+	t.Run("concurrent owners", func(t *testing.T) {
+		dir := t.TempDir()
+
+		owners := atomic.Int32{}
+		overlapped := atomic.Bool{}
+
+		wg := sync.WaitGroup{}
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				unlock, err := lockIndex(context.Background(), dir)
+				assert.NoError(t, err)
+				defer unlock()
+
+				if owners.Add(1) > 1 {
+					overlapped.Store(true)
+				}
+				time.Sleep(10 * time.Millisecond)
+				owners.Add(-1)
+			}()
+		}
+		wg.Wait()
+
+		assert.False(t, overlapped.Load())
+	})
+}
+
+func TestRunIndexReusesConcurrentResult(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	md, err := GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+
+	// The first process indexes the release
+	err = runIndex(context.Background(), cacheDir, Index{
+		Name:     "test",
+		Fetcher:  &testFetcher{release: "v1", data: `{"packages": {"pkg": {}}}`},
+		Metadata: md,
+	})
+	assert.NoError(t, err)
+
+	// The second one started with the same metadata, so must not
+	// touch the fetcher
+	err = runIndex(context.Background(), cacheDir, Index{
+		Name:     "test",
+		Fetcher:  &testFetcher{release: "v2", data: `{"packages": {`},
+		Metadata: md,
+	})
+	assert.NoError(t, err)
+
+	md, err = GetIndexMetadata(cacheDir, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", md.CurrRelease)
+}
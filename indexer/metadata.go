@@ -36,16 +36,15 @@ func GetIndexMetadata(cacheDir, indexName string) (IndexMetadata, error) {
 }
 
 func setIndexMetadata(dir string, md IndexMetadata) error {
-	mdpath, err := initFile(dir, metadataFile, []byte("{}"))
-	if err != nil {
-		return fmt.Errorf("init metadata: %w", err)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create directory: %w", err)
 	}
 
 	data, err := json.Marshal(md)
 	if err != nil {
 		return fmt.Errorf("marshal metadata: %w", err)
 	}
-	err = os.WriteFile(mdpath, data, 0666)
+	err = writeFileAtomic(filepath.Join(dir, metadataFile), data)
 	if err != nil {
 		return fmt.Errorf("write metadata: %w", err)
 	}
@@ -53,6 +52,29 @@ func setIndexMetadata(dir string, md IndexMetadata) error {
 	return nil
 }
 
+// writeFileAtomic writes the data into a temporary file first and then
+// renames it, so that readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
 func CacheWriter(dir string) (io.WriteCloser, error) {
 	cpath, err := initFile(dir, cacheFile, nil)
 	if err != nil {
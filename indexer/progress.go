@@ -0,0 +1,201 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Phase string
+
+const (
+	PhaseChecking    Phase = "checking release"
+	PhaseDownloading Phase = "downloading"
+	PhaseParsing     Phase = "parsing"
+	PhaseWriting     Phase = "writing"
+	PhaseDone        Phase = "done"
+)
+
+// Progress is a snapshot of how far indexing of an index went
+type Progress struct {
+	Index     string        `json:"index"`
+	Phase     Phase         `json:"phase"`
+	Bytes     int64         `json:"bytes"`
+	Packages  int           `json:"packages"`
+	Elapsed   time.Duration `json:"elapsed"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// progressFile is where the progress of the running indexing is kept,
+// so that other processes, like previews, can show it
+const progressFile = "progress.json"
+
+const (
+	progressInterval = 200 * time.Millisecond
+
+	// Progress files that have not been updated for this long
+	// are left from an indexing that crashed
+	staleProgressAge = 10 * time.Second
+)
+
+// progress counts read bytes and parsed packages. It is
+// updated by the indexing goroutine and reported periodically
+// by another one, as well as on every phase change
+type progress struct {
+	index    string
+	path     string
+	start    time.Time
+	bytes    atomic.Int64
+	packages atomic.Int64
+
+	// downloaded is what came over the network, while bytes is what the
+	// fetcher made of it, e.g. the decompressed JSON, which is usually more
+	downloaded atomic.Int64
+
+	// mu serializes the reports
+	mu    sync.Mutex
+	phase Phase
+	fn    func(Progress)
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// startProgress starts reporting the progress of the indexing to
+// fn and the progress file in the index directory until finish is called
+func startProgress(indexDir, index string, fn func(Progress)) *progress {
+	p := &progress{
+		index:   index,
+		path:    filepath.Join(indexDir, progressFile),
+		start:   time.Now(),
+		phase:   PhaseChecking,
+		fn:      fn,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	p.report()
+
+	go func() {
+		defer close(p.stopped)
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.report()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+func (p *progress) setPhase(phase Phase) {
+	p.mu.Lock()
+	p.phase = phase
+	p.mu.Unlock()
+
+	p.report()
+}
+
+// finish stops the reporting and reports the done phase
+func (p *progress) finish() {
+	close(p.stop)
+	<-p.stopped
+
+	p.setPhase(PhaseDone)
+	_ = os.Remove(p.path)
+}
+
+func (p *progress) report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snap := Progress{
+		Index:     p.index,
+		Phase:     p.phase,
+		Bytes:     p.bytes.Load(),
+		Packages:  int(p.packages.Load()),
+		Elapsed:   time.Since(p.start),
+		UpdatedAt: time.Now(),
+	}
+
+	if snap.Phase != PhaseDone {
+		if data, err := json.Marshal(snap); err == nil {
+			_ = writeFileAtomic(p.path, data)
+		}
+	}
+	if p.fn != nil {
+		p.fn(snap)
+	}
+}
+
+// ReadProgress returns the progress of the indexes
+// that are being indexed right now
+func ReadProgress(cacheDir string) ([]Progress, error) {
+	paths, err := filepath.Glob(filepath.Join(cacheDir, "*", progressFile))
+	if err != nil {
+		return nil, err
+	}
+
+	running := []Progress{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// Most likely, the indexing has just finished
+			continue
+		}
+
+		prog := Progress{}
+		if err := json.Unmarshal(data, &prog); err != nil {
+			continue
+		}
+		if time.Since(prog.UpdatedAt) > staleProgressAge {
+			continue
+		}
+
+		running = append(running, prog)
+	}
+
+	slices.SortFunc(running, func(a, b Progress) int {
+		return strings.Compare(a.Index, b.Index)
+	})
+
+	return running, nil
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	rd io.Reader
+	n  *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// lineCounter counts the lines written into the underlying writer,
+// which for the cache file is the number of packages
+type lineCounter struct {
+	wr io.Writer
+	n  *atomic.Int64
+}
+
+func (w *lineCounter) Write(p []byte) (int, error) {
+	n, err := w.wr.Write(p)
+	w.n.Add(int64(bytes.Count(p[:n], []byte{'\n'})))
+	return n, err
+}
@@ -0,0 +1,70 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
+)
+
+// Store keeps indexed packages on disk
+type Store interface {
+	// Index replaces the store content with the packages from data
+	// and writes the names of the indexed ones into indexedKeys
+	Index(data io.Reader, opts jsonstream.Options, indexedKeys io.Writer) error
+
+	// Load returns the content of a package
+	Load(key string) (json.RawMessage, error)
+
+	// Keys calls fn for every package name in lexicographical order
+	Keys(fn func(key string) error) error
+
+	// Scan calls fn for every package whose name starts with prefix
+	// in lexicographical order. The content is only valid until fn returns
+	Scan(prefix string, fn func(key string, content []byte) error) error
+
+	Close() error
+}
+
+// lookupFile is a read-only copy of a badger store
+// in the FileStore format
+const lookupFile = "lookup.dat"
+
+const (
+	StoreBadger = "badger"
+	StoreFile   = "file"
+)
+
+var (
+	_ Store = (*Badger)(nil)
+	_ Store = (*FileStore)(nil)
+)
+
+var ErrUnknownStore = errors.New("unknown store")
+
+func ValidateStore(kind string) error {
+	switch kind {
+	case "", StoreBadger, StoreFile:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownStore, kind)
+}
+
+// OpenStore opens a store of the given kind in the directory
+// of an index generation
+func OpenStore(kind, dir string) (Store, error) {
+	switch kind {
+	case "", StoreBadger:
+		return NewBadger(BadgerConfig{
+			Dir: filepath.Join(dir, badgerDir),
+		})
+
+	case StoreFile:
+		return NewFileStore(filepath.Join(dir, storeFile)), nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownStore, kind)
+}
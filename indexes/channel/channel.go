@@ -0,0 +1,131 @@
+// Package channel maps nixpkgs and NixOS channels to where their releases
+// are stored in the nix-releases bucket and to the branches they are built from.
+//
+// Channels are named the same as their branches in the nixpkgs repository, e.g.
+// nixpkgs-unstable, nixos-unstable, nixos-25.05, nixos-25.05-small or nixpkgs-25.05-darwin
+package channel
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	NixpkgsUnstable = "nixpkgs-unstable"
+	NixOSUnstable   = "nixos-unstable"
+)
+
+// ReleasesBucketURL is where the nix-releases bucket listing the releases of all channels is
+const ReleasesBucketURL = "https://nix-releases.s3.eu-west-1.amazonaws.com"
+
+var ErrUnknownChannel = errors.New("unknown channel")
+
+var channelRe = regexp.MustCompile(
+	`^(nixpkgs-unstable|nixos-unstable(-small)?|nixos-\d{2}\.\d{2}(-small)?|nixpkgs-\d{2}\.\d{2}-darwin)$`,
+)
+
+func Validate(channel string) error {
+	if !channelRe.MatchString(channel) {
+		return fmt.Errorf(
+			"%w: %q, expected one of nixpkgs-unstable, nixos-unstable, nixos-YY.MM, nixpkgs-YY.MM-darwin or their -small variants",
+			ErrUnknownChannel, channel,
+		)
+	}
+
+	return nil
+}
+
+// IsNixOS reports whether the channel is a NixOS one. Only NixOS
+// channels have options.json in their releases
+func IsNixOS(channel string) bool {
+	return strings.HasPrefix(channel, "nixos-")
+}
+
+// S3Prefix returns the prefix of the channel releases in the
+// nix-releases bucket, e.g.
+//
+//	nixpkgs-unstable     -> nixpkgs/
+//	nixos-unstable       -> nixos/unstable/
+//	nixos-25.05          -> nixos/25.05/
+//	nixpkgs-25.05-darwin -> nixpkgs/25.05-darwin/
+func S3Prefix(channel string) string {
+	if channel == NixpkgsUnstable {
+		return "nixpkgs/"
+	}
+
+	kind, version, _ := strings.Cut(channel, "-")
+	return kind + "/" + version + "/"
+}
+
+// HasRelease reports whether the release is of the channel
+func HasRelease(channel, release string) bool {
+	name, ok := strings.CutPrefix(release, S3Prefix(channel))
+	// nixpkgs/ is also a prefix of nixpkgs/25.05-darwin/
+	return ok && !strings.Contains(name, "/")
+}
+
+// The release names end with the revision, i.e. the number of commits in
+// the branch, and the commit hash. Pre-releases have "pre" or "beta" right
+// after the version, e.g. nixos-25.05beta751650.64e75cd44acf
+var (
+	revisionRe   = regexp.MustCompile(`(\d+)\.[0-9a-f]+$`)
+	preReleaseRe = regexp.MustCompile(`\d{2}\.\d{2}(pre|beta)\d+\.[0-9a-f]+$`)
+)
+
+// Revision returns the revision the release was built from, or -1
+// if the release is not named as expected
+func Revision(release string) int {
+	m := revisionRe.FindStringSubmatch(release)
+	if m == nil {
+		return -1
+	}
+	rev, err := strconv.Atoi(m[1])
+	if err != nil {
+		return -1
+	}
+	return rev
+}
+
+// CompareReleases orders the releases by their revisions. Unlike the keys,
+// the revisions place the pre-releases of stable channels before their
+// final releases, e.g. nixos-25.05beta751650.64e75cd44acf before
+// nixos-25.05.802216.55d1f923c480
+func CompareReleases(a, b string) int {
+	if c := cmp.Compare(Revision(a), Revision(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// ListAfter reports whether all the releases of the channel newer than
+// the given one have greater keys, so that the listing can start after
+// it. The final releases have smaller keys than the pre-releases of the
+// same version, and only stable channels have them
+func ListAfter(channel, release string) bool {
+	if !HasRelease(channel, release) {
+		return false
+	}
+	return !preReleaseRe.MatchString(release) || strings.Contains(channel, "unstable")
+}
+
+// ReleasesURL is where the files of the releases are served from
+const ReleasesURL = "https://releases.nixos.org"
+
+// ReleaseURL returns the URL of a file of the release
+// served from base, i.e. ReleasesURL or its mirror
+func ReleaseURL(base, release, file string) string {
+	return strings.TrimSuffix(base, "/") + "/" + strings.Trim(release, "/") + "/" + file
+}
+
+// SearchName returns how search.nixos.org calls the channel,
+// i.e. "unstable" or the version like "25.05"
+func SearchName(channel string) string {
+	_, version, _ := strings.Cut(channel, "-")
+	version = strings.TrimSuffix(version, "-small")
+	version = strings.TrimSuffix(version, "-darwin")
+	return version
+}
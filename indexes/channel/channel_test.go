@@ -0,0 +1,66 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestValidate(t *testing.T) {
+	for _, ch := range []string{
+		"nixpkgs-unstable",
+		"nixos-unstable",
+		"nixos-unstable-small",
+		"nixos-25.05",
+		"nixos-25.05-small",
+		"nixpkgs-25.05-darwin",
+	} {
+		assert.NoError(t, Validate(ch), ch)
+	}
+
+	for _, ch := range []string{"", "unstable", "nixos-25", "nixpkgs-25.05", "nixos-25.05-darwin"} {
+		assert.IsError(t, Validate(ch), ErrUnknownChannel, ch)
+	}
+}
+
+func TestS3Prefix(t *testing.T) {
+	assert.Equal(t, "nixpkgs/", S3Prefix("nixpkgs-unstable"))
+	assert.Equal(t, "nixos/unstable/", S3Prefix("nixos-unstable"))
+	assert.Equal(t, "nixos/unstable-small/", S3Prefix("nixos-unstable-small"))
+	assert.Equal(t, "nixos/25.05/", S3Prefix("nixos-25.05"))
+	assert.Equal(t, "nixpkgs/25.05-darwin/", S3Prefix("nixpkgs-25.05-darwin"))
+}
+
+func TestHasRelease(t *testing.T) {
+	assert.True(t, HasRelease("nixpkgs-unstable", "nixpkgs/nixpkgs-25.05pre747523.95ea544c84eb"))
+	assert.False(t, HasRelease("nixpkgs-unstable", "nixpkgs/25.05-darwin/nixpkgs-darwin-25.05.1.abc"))
+	assert.True(t, HasRelease("nixos-25.05", "nixos/25.05/nixos-25.05.802491.7c43f080a7f2"))
+	assert.False(t, HasRelease("nixos-25.05", "nixos/unstable/nixos-25.11pre.abc"))
+}
+
+func TestCompareReleases(t *testing.T) {
+	assert.Equal(t, 802216, Revision("nixos/25.05/nixos-25.05.802216.55d1f923c480"))
+	assert.Equal(t, 751650, Revision("nixos/25.05/nixos-25.05beta751650.64e75cd44acf"))
+	assert.Equal(t, -1, Revision("nixos/25.05/"))
+
+	beta := "nixos/25.05/nixos-25.05beta751650.64e75cd44acf"
+	final := "nixos/25.05/nixos-25.05.802216.55d1f923c480"
+	assert.True(t, beta > final)
+	assert.Equal(t, -1, CompareReleases(beta, final))
+	assert.Equal(t, 1, CompareReleases(final, beta))
+}
+
+func TestListAfter(t *testing.T) {
+	assert.True(t, ListAfter("nixos-25.05", "nixos/25.05/nixos-25.05.802216.55d1f923c480"))
+	assert.False(t, ListAfter("nixos-25.05", "nixos/25.05/nixos-25.05beta751650.64e75cd44acf"))
+	assert.False(t, ListAfter("nixos-25.05", "nixos/unstable/nixos-25.11pre1.abc"))
+	assert.True(t, ListAfter("nixos-unstable", "nixos/unstable/nixos-25.11pre1.abc"))
+	assert.True(t, ListAfter("nixpkgs-unstable", "nixpkgs/nixpkgs-25.11pre1.abc"))
+}
+
+func TestSearchName(t *testing.T) {
+	assert.Equal(t, "unstable", SearchName("nixpkgs-unstable"))
+	assert.Equal(t, "unstable", SearchName("nixos-unstable-small"))
+	assert.Equal(t, "25.05", SearchName("nixos-25.05"))
+	assert.Equal(t, "25.05", SearchName("nixpkgs-25.05-darwin"))
+}
@@ -1,70 +1,63 @@
 package darwin
 
 import (
-	"bytes"
+	"cmp"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"strings"
-	"time"
+	"net/http"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
 	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
 	"github.com/3timeslazy/nix-search-tv/pkgs/renderdocs"
-
-	"github.com/antchfx/htmlquery"
-	"golang.org/x/net/html"
 )
 
 const htmlURL = "https://nix-darwin.github.io/nix-darwin/manual/index.html"
 
-type Fetcher struct{}
+type Fetcher struct {
+	// Mirror is the URL of the manual page to
+	// download instead of the official one
+	Mirror string
 
-func (Fetcher) GetLatestRelease(_ context.Context, _ indexer.IndexMetadata) (string, error) {
-	return time.Now().String(), nil
-}
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
 
-func (Fetcher) DownloadRelease(_ context.Context, release string) (io.ReadCloser, error) {
-	var doc *html.Node
-	var err error
+	page httpclient.ContentCache
+}
 
-	_, path, ok := strings.Cut(release, "file://")
-	if ok {
-		doc, err = htmlquery.LoadDoc(path)
-	} else {
-		doc, err = htmlquery.LoadURL(htmlURL)
-	}
-	if err != nil {
-		return nil, fmt.Errorf("download options.xhtml: %w", err)
-	}
+// GetLatestRelease returns the version of the manual page, so
+// that unchanged pages are not downloaded and indexed again
+func (f *Fetcher) GetLatestRelease(ctx context.Context, md indexer.IndexMetadata) (string, error) {
+	client := cmp.Or(f.HTTPClient, http.DefaultClient)
+	return f.page.Version(ctx, client, cmp.Or(f.Mirror, htmlURL), md.CurrRelease)
+}
 
-	htmlPkgs, err := renderdocs.Parse(doc)
+func (f *Fetcher) DownloadRelease(ctx context.Context, release string) (io.ReadCloser, error) {
+	url := cmp.Or(f.Mirror, htmlURL)
+	page, err := readutil.OpenPage(ctx, f.HTTPClient, &f.page, release, url)
 	if err != nil {
-		return nil, fmt.Errorf("parse options.xhtml: %w", err)
+		return nil, fmt.Errorf("download %s: %w", url, err)
 	}
 
-	pkgs := map[string]Package{}
-	for name, htmlPkg := range htmlPkgs {
-		pkg := Package{
-			Package: indexer.Package{
-				Name: htmlPkg.Name,
-			},
-			Example:     htmlPkg.Example,
-			Type:        htmlPkg.Type,
-			Description: htmlPkg.Description,
-			Default:     htmlPkg.Default,
-			DeclaredBy:  htmlPkg.DeclaredBy,
+	return readutil.StreamPackages(func(pw *readutil.PackagesWriter) error {
+		defer page.Close()
+
+		err := renderdocs.WalkReader(page, func(htmlPkg renderdocs.Package) error {
+			return pw.Write(htmlPkg.Name, Package{
+				Package: indexer.Package{
+					Name: htmlPkg.Name,
+				},
+				Example:     htmlPkg.Example,
+				Type:        htmlPkg.Type,
+				Description: htmlPkg.Description,
+				Default:     htmlPkg.Default,
+				DeclaredBy:  htmlPkg.DeclaredBy,
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("parse manual: %w", err)
 		}
-
-		pkgs[name] = pkg
-	}
-
-	buf := &bytes.Buffer{}
-	err = json.NewEncoder(buf).Encode(pkgs)
-	if err != nil {
-		return nil, fmt.Errorf("encode json: %w", err)
-	}
-
-	return readutil.PackagesWrapper(io.NopCloser(buf)), nil
+		return nil
+	}), nil
 }
@@ -1,82 +1,79 @@
 package homemanager
 
 import (
-	"bytes"
+	"cmp"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"strings"
-	"time"
+	"net/http"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
 	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
 	"github.com/3timeslazy/nix-search-tv/pkgs/renderdocs"
-
-	"github.com/antchfx/htmlquery"
-	"golang.org/x/net/html"
 )
 
-type Fetcher struct{}
+type Fetcher struct {
+	// Mirror is the URL of options.xhtml to
+	// download instead of the official one
+	Mirror string
+
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+
+	page httpclient.ContentCache
+}
 
 const htmlURL = "https://nix-community.github.io/home-manager/options.xhtml"
 
-func (Fetcher) GetLatestRelease(ctx context.Context, md indexer.IndexMetadata) (string, error) {
-	return time.Now().String(), nil
+// SchemaVersion implements indexer.SchemaFetcher. The options are stored
+// in the format of the old `nix build` fetcher, see DownloadRelease. Once
+// it changes, bumping the version re-indexes what was stored the old way
+func (*Fetcher) SchemaVersion() int {
+	return 0
 }
 
-func (Fetcher) DownloadRelease(_ context.Context, release string) (io.ReadCloser, error) {
-	var doc *html.Node
-	var err error
+// GetLatestRelease returns the version of options.xhtml, so that
+// unchanged pages are not downloaded and indexed again
+func (f *Fetcher) GetLatestRelease(ctx context.Context, md indexer.IndexMetadata) (string, error) {
+	client := cmp.Or(f.HTTPClient, http.DefaultClient)
+	return f.page.Version(ctx, client, cmp.Or(f.Mirror, htmlURL), md.CurrRelease)
+}
 
-	_, path, ok := strings.Cut(release, "file://")
-	if ok {
-		doc, err = htmlquery.LoadDoc(path)
-	} else {
-		doc, err = htmlquery.LoadURL(htmlURL)
-	}
+func (f *Fetcher) DownloadRelease(ctx context.Context, release string) (io.ReadCloser, error) {
+	page, err := readutil.OpenPage(ctx, f.HTTPClient, &f.page, release, cmp.Or(f.Mirror, htmlURL))
 	if err != nil {
 		return nil, fmt.Errorf("download options.xhtml: %w", err)
 	}
 
-	htmlPkgs, err := renderdocs.Parse(doc)
-	if err != nil {
-		return nil, fmt.Errorf("parse options.xhtml: %w", err)
-	}
-
-	// `Package` represents how data is stored
-	// on disk. Its structure inhered from previous `nix build` fetcher.
-	// To not break compatibility with older versions, convert
-	// it here
-	pkgs := map[string]Package{}
-	for name, htmlPkg := range htmlPkgs {
-		pkg := Package{
-			Package: indexer.Package{
-				Name: htmlPkg.Name,
-			},
-			Example: Example{
-				Text: htmlPkg.Example,
-			},
-			Type:        htmlPkg.Type,
-			Description: htmlPkg.Description,
-			Default: Default{
-				Text: htmlPkg.Default,
-			},
-		}
-		for _, decl := range htmlPkg.DeclaredBy {
-			pkg.Declarations = append(pkg.Declarations, Declarations{
-				URL: decl,
-			})
-		}
-
-		pkgs[name] = pkg
-	}
+	return readutil.StreamPackages(func(pw *readutil.PackagesWriter) error {
+		defer page.Close()
 
-	buf := &bytes.Buffer{}
-	err = json.NewEncoder(buf).Encode(pkgs)
-	if err != nil {
-		return nil, fmt.Errorf("encode json: %w", err)
-	}
+		err := renderdocs.WalkReader(page, func(htmlPkg renderdocs.Package) error {
+			pkg := Package{
+				Package: indexer.Package{
+					Name: htmlPkg.Name,
+				},
+				Example: Example{
+					Text: htmlPkg.Example,
+				},
+				Type:        htmlPkg.Type,
+				Description: htmlPkg.Description,
+				Default: Default{
+					Text: htmlPkg.Default,
+				},
+			}
+			for _, decl := range htmlPkg.DeclaredBy {
+				pkg.Declarations = append(pkg.Declarations, Declarations{
+					URL: decl,
+				})
+			}
 
-	return readutil.PackagesWrapper(io.NopCloser(buf)), nil
+			return pw.Write(htmlPkg.Name, pkg)
+		})
+		if err != nil {
+			return fmt.Errorf("parse options.xhtml: %w", err)
+		}
+		return nil
+	}), nil
 }
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/textutil"
 )
 
 type Package struct {
@@ -48,3 +49,7 @@ func (pkg *Package) GetSource() string {
 func (pkg *Package) GetHomepage() string {
 	return pkg.GetSource()
 }
+
+func (pkg *Package) GetDescription() string {
+	return textutil.StripHTML(pkg.Description)
+}
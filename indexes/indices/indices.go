@@ -10,20 +10,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/channel"
 	"github.com/3timeslazy/nix-search-tv/indexes/darwin"
 	"github.com/3timeslazy/nix-search-tv/indexes/homemanager"
+	"github.com/3timeslazy/nix-search-tv/indexes/jsonfile"
 	"github.com/3timeslazy/nix-search-tv/indexes/nixos"
 	"github.com/3timeslazy/nix-search-tv/indexes/nixpkgs"
 	"github.com/3timeslazy/nix-search-tv/indexes/noogle"
 	"github.com/3timeslazy/nix-search-tv/indexes/nur"
+	"github.com/3timeslazy/nix-search-tv/indexes/renderdocs"
 )
 
 type Pkg interface {
 	Preview(io.Writer)
 	GetSource() string
 	GetHomepage() string
+	GetDescription() string
+}
+
+// MainProgramPkg is implemented by packages providing an executable
+type MainProgramPkg interface {
+	GetMainProgram() string
 }
 
 const (
@@ -44,13 +55,17 @@ var BuiltinIndexes = map[string]bool{
 	Noogle:      true,
 }
 
-var newPkgs = map[string]func() Pkg{
-	Nixpkgs:     func() Pkg { return &nixpkgs.Package{} },
-	HomeManager: func() Pkg { return &homemanager.Package{} },
-	Nur:         func() Pkg { return &nur.Package{} },
-	NixOS:       func() Pkg { return &nixos.Package{} },
-	Darwin:      func() Pkg { return &darwin.Package{} },
-	Noogle:      func() Pkg { return &noogle.Package{} },
+var newPkgs = builtinNewPkgs()
+
+func builtinNewPkgs() map[string]func() Pkg {
+	return map[string]func() Pkg{
+		Nixpkgs:     func() Pkg { return &nixpkgs.Package{} },
+		HomeManager: func() Pkg { return &homemanager.Package{} },
+		Nur:         func() Pkg { return &nur.Package{} },
+		NixOS:       func() Pkg { return &nixos.Package{} },
+		Darwin:      func() Pkg { return &darwin.Package{} },
+		Noogle:      func() Pkg { return &noogle.Package{} },
+	}
 }
 
 var fetchers = map[string]indexer.Fetcher{
@@ -80,6 +95,129 @@ func Register(
 	return nil
 }
 
+// SetChannel makes the index fetch packages from the channel
+// and point source links to its branch
+func SetChannel(index, ch string) error {
+	fetcher, newpkg, err := channelIndex(index, ch)
+	if err != nil {
+		return err
+	}
+
+	fetchers[index] = fetcher
+	newPkgs[index] = newpkg
+	return nil
+}
+
+// RegisterChannel registers another index of the same kind as the
+// builtin one, but fetching packages from the given channel. That way,
+// e.g. stable and unstable nixpkgs can be searched side by side
+func RegisterChannel(index, builtin, ch string) error {
+	fetcher, newpkg, err := channelIndex(builtin, ch)
+	if err != nil {
+		return err
+	}
+
+	return Register(index, fetcher, newpkg)
+}
+
+// SetS3Endpoint points the fetchers listing releases of
+// channels to another nix-releases bucket URL, e.g. a mirror
+func SetS3Endpoint(endpoint string) {
+	for _, fetcher := range fetchers {
+		switch fetcher := fetcher.(type) {
+		case *nixpkgs.Fetcher:
+			fetcher.S3Endpoint = endpoint
+		case *nixos.Fetcher:
+			fetcher.S3Endpoint = endpoint
+		}
+	}
+}
+
+// SetMirror makes the index download its data from
+// the mirror instead of the official URLs
+func SetMirror(index, mirror string) error {
+	u, err := url.Parse(mirror)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid mirror URL %q", mirror)
+	}
+
+	switch fetcher := fetchers[index].(type) {
+	case *nixpkgs.Fetcher:
+		fetcher.Mirror = mirror
+	case *nixos.Fetcher:
+		fetcher.Mirror = mirror
+	case *homemanager.Fetcher:
+		fetcher.Mirror = mirror
+	case *darwin.Fetcher:
+		fetcher.Mirror = mirror
+	case *noogle.Fetcher:
+		fetcher.Mirror = mirror
+	case *nur.Fetcher:
+		fetcher.Mirror = mirror
+	default:
+		return fmt.Errorf("index %q does not support mirrors", index)
+	}
+
+	return nil
+}
+
+// SetHTTPClient makes all the fetchers downloading
+// over HTTP use the client
+func SetHTTPClient(client *http.Client) {
+	for _, fetcher := range fetchers {
+		switch fetcher := fetcher.(type) {
+		case *nixpkgs.Fetcher:
+			fetcher.HTTPClient = client
+		case *nixos.Fetcher:
+			fetcher.HTTPClient = client
+		case *homemanager.Fetcher:
+			fetcher.HTTPClient = client
+		case *darwin.Fetcher:
+			fetcher.HTTPClient = client
+		case *noogle.Fetcher:
+			fetcher.HTTPClient = client
+		case *nur.Fetcher:
+			fetcher.HTTPClient = client
+		case *renderdocs.Fetcher:
+			fetcher.HTTPClient = client
+		case *jsonfile.Fetcher:
+			fetcher.HTTPClient = client
+		}
+	}
+}
+
+// SetGitHubToken makes the fetchers calling the
+// GitHub API authenticate with the token
+func SetGitHubToken(token string) {
+	for _, fetcher := range fetchers {
+		if fetcher, ok := fetcher.(*nur.Fetcher); ok {
+			fetcher.Token = token
+		}
+	}
+}
+
+func channelIndex(index, ch string) (indexer.Fetcher, func() Pkg, error) {
+	if err := channel.Validate(ch); err != nil {
+		return nil, nil, err
+	}
+
+	switch index {
+	case Nixpkgs:
+		newpkg := func() Pkg { return &nixpkgs.Package{Branch: ch} }
+		return nixpkgs.NewFetcher(ch), newpkg, nil
+
+	case NixOS:
+		if !channel.IsNixOS(ch) {
+			return nil, nil, fmt.Errorf("%w: %q has no NixOS options, use a nixos-* channel", channel.ErrUnknownChannel, ch)
+		}
+		newpkg := func() Pkg { return &nixos.Package{Channel: ch} }
+		return nixos.NewFetcher(ch), newpkg, nil
+
+	default:
+		return nil, nil, fmt.Errorf("index %q does not support channels", index)
+	}
+}
+
 func Preview(index string, out io.Writer, pkgContent json.RawMessage) error {
 	pkg, err := getPkg(index, pkgContent)
 	if err != nil {
@@ -110,6 +248,11 @@ func HomepagePreview(index string, out io.Writer, pkgContent json.RawMessage) er
 	return err
 }
 
+// Decode decodes the content of a package of the given index
+func Decode(index string, pkgContent json.RawMessage) (Pkg, error) {
+	return getPkg(index, pkgContent)
+}
+
 func registerNewPkg(index string, newpkg func() Pkg) error {
 	if _, ok := newPkgs[index]; ok {
 		return fmt.Errorf("index %q already registered", index)
@@ -153,7 +296,9 @@ func SetFetchers(newFetchers map[string]indexer.Fetcher) {
 	fetchers = newFetchers
 }
 
+// Reset removes all the fetchers and custom indexes
+// and only used for testing
 func Reset() {
 	fetchers = map[string]indexer.Fetcher{}
-	newPkgs = map[string]func() Pkg{}
+	newPkgs = builtinNewPkgs()
 }
@@ -0,0 +1,80 @@
+// Package jsonfile indexes JSON documents that have the packages
+// somewhere in them, e.g. API responses or options.json files,
+// without a fetcher written for every one of them
+package jsonfile
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
+)
+
+type Fetcher struct {
+	source string
+	opts   jsonstream.Options
+
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+
+	doc httpclient.ContentCache
+}
+
+var _ indexer.ParseFetcher = (*Fetcher)(nil)
+
+// NewFetcher creates a fetcher of the document at source,
+// which is either an http(s) URL or a local path
+func NewFetcher(source string, opts jsonstream.Options) *Fetcher {
+	return &Fetcher{
+		source: source,
+		opts:   opts,
+	}
+}
+
+func (f *Fetcher) ParseOptions() jsonstream.Options {
+	return f.opts
+}
+
+// GetLatestRelease returns the version of the document. For URLs it's
+// the same as for HTML pages, for files it's their size and mtime
+func (f *Fetcher) GetLatestRelease(ctx context.Context, md indexer.IndexMetadata) (string, error) {
+	if !f.remote() {
+		info, err := os.Stat(f.source)
+		if err != nil {
+			return "", fmt.Errorf("stat %s: %w", f.source, err)
+		}
+		return fmt.Sprintf("file:%d:%d", info.Size(), info.ModTime().UnixNano()), nil
+	}
+
+	client := cmp.Or(f.HTTPClient, http.DefaultClient)
+	return f.doc.Version(ctx, client, f.source, md.CurrRelease)
+}
+
+func (f *Fetcher) DownloadRelease(ctx context.Context, release string) (io.ReadCloser, error) {
+	if !f.remote() {
+		file, err := os.Open(f.source)
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", f.source, err)
+		}
+		return file, nil
+	}
+
+	client := cmp.Or(f.HTTPClient, http.DefaultClient)
+	body, err := f.doc.Get(ctx, client, f.source, release)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", f.source, err)
+	}
+
+	return body, nil
+}
+
+func (f *Fetcher) remote() bool {
+	return strings.HasPrefix(f.source, "http://") || strings.HasPrefix(f.source, "https://")
+}
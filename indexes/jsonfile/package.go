@@ -0,0 +1,134 @@
+package jsonfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/json/jsontext"
+	"fmt"
+	"io"
+
+	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/textutil"
+	"github.com/3timeslazy/nix-search-tv/style"
+)
+
+// Package is whatever object the document has. Its fields
+// are previewed as they are, in the order of the document
+type Package struct {
+	indexer.Package
+	Fields []Field
+}
+
+type Field struct {
+	Name  string
+	Value jsontext.Value
+}
+
+func (pkg *Package) UnmarshalJSON(data []byte) error {
+	dec := jsontext.NewDecoder(bytes.NewReader(data))
+
+	t, err := dec.ReadToken()
+	if err != nil {
+		return err
+	}
+	if t.Kind() != '{' {
+		return fmt.Errorf("expected package as object, but got %s", t.Kind())
+	}
+
+	for {
+		t, err := dec.ReadToken()
+		if err != nil {
+			return err
+		}
+		if t.Kind() == '}' {
+			return nil
+		}
+		name := t.String()
+
+		value, err := dec.ReadValue()
+		if err != nil {
+			return err
+		}
+
+		if name == "_key" {
+			if err := json.Unmarshal(value, &pkg.Name); err != nil {
+				return fmt.Errorf("unmarshal name: %w", err)
+			}
+			continue
+		}
+		pkg.Fields = append(pkg.Fields, Field{
+			Name:  name,
+			Value: value.Clone(),
+		})
+	}
+}
+
+func (pkg *Package) Preview(out io.Writer) {
+	fmt.Fprintln(out, textutil.PkgName(pkg.Name))
+
+	if desc := pkg.GetDescription(); desc != "" {
+		fmt.Fprintln(out, style.StyleLongDescription(style.TextStyle, desc))
+		fmt.Fprintln(out)
+	}
+
+	for _, field := range pkg.Fields {
+		if field.Name == "description" {
+			continue
+		}
+
+		text, ok := str(field.Value)
+		if !ok {
+			value := field.Value.Clone()
+			if err := value.Indent(); err != nil {
+				continue
+			}
+			text = style.PrintCodeBlock(string(value))
+		}
+		if text == "" {
+			continue
+		}
+
+		fmt.Fprintln(out, textutil.Prop(field.Name, "", text))
+	}
+}
+
+func (pkg *Package) GetSource() string {
+	src, _ := pkg.field("source")
+	return src
+}
+
+func (pkg *Package) GetHomepage() string {
+	if homepage, ok := pkg.field("homepage"); ok {
+		return homepage
+	}
+	url, _ := pkg.field("url")
+	return url
+}
+
+func (pkg *Package) GetDescription() string {
+	desc, _ := pkg.field("description")
+	return desc
+}
+
+// field returns the string field of the package
+func (pkg *Package) field(name string) (string, bool) {
+	for _, field := range pkg.Fields {
+		if field.Name == name {
+			return str(field.Value)
+		}
+	}
+
+	return "", false
+}
+
+func str(value jsontext.Value) (string, bool) {
+	if value.Kind() != '"' {
+		return "", false
+	}
+
+	s := ""
+	if err := json.Unmarshal(value, &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
@@ -6,64 +6,92 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"strings"
+	"slices"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/channel"
 	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
+	"github.com/3timeslazy/nix-search-tv/pkgs/s3"
 )
 
-type Fetcher struct{}
+type Fetcher struct {
+	// Channel to fetch the options of. Empty means nixos-unstable
+	Channel string
+
+	// S3Endpoint is the URL of the nix-releases bucket
+	// or its mirror. Empty means the official bucket
+	S3Endpoint string
+
+	// Mirror replaces https://releases.nixos.org
+	// when downloading releases
+	Mirror string
 
-const prefix = "nixos/unstable/"
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+func NewFetcher(ch string) *Fetcher {
+	return &Fetcher{Channel: ch}
+}
+
+func (f *Fetcher) channel() string {
+	return cmp.Or(f.Channel, channel.NixOSUnstable)
+}
+
+// ReleasePrefix implements indexer.ChannelFetcher
+func (f *Fetcher) ReleasePrefix() string {
+	return channel.S3Prefix(f.channel())
+}
 
 func (f *Fetcher) GetLatestRelease(ctx context.Context, md indexer.IndexMetadata) (string, error) {
-	s3client := s3.NewFromConfig(aws.Config{
-		Region: "eu-west-1",
-	})
+	s3client := &s3.Client{
+		BucketURL:  cmp.Or(f.S3Endpoint, channel.ReleasesBucketURL),
+		HTTPClient: f.HTTPClient,
+	}
 
 	// The `startAfter` is a marker for S3 to start iterating from. Just use the latest
 	// at the moment of writing nixpkgs release to never iterate from the beginning
-	startAfter := cmp.Or(md.CurrRelease, "nixos/unstable/nixos-25.05beta751650.64e75cd44acf")
-	var latest types.Object
-	input := &s3.ListObjectsV2Input{
-		Bucket:     aws.String("nix-releases"),
-		Prefix:     aws.String(prefix),
-		Delimiter:  aws.String("/"),
-		StartAfter: aws.String(startAfter),
+	startAfter := ""
+	if f.channel() == channel.NixOSUnstable {
+		startAfter = "nixos/unstable/nixos-25.05beta751650.64e75cd44acf"
+	}
+	// The current release is of another channel if the channel has changed
+	if channel.ListAfter(f.channel(), md.CurrRelease) {
+		startAfter = md.CurrRelease
+	}
+
+	objects, err := s3client.ListObjects(ctx, s3.ListInput{
+		Prefix:     f.ReleasePrefix(),
+		Delimiter:  "/",
+		StartAfter: startAfter,
+	})
+	if err != nil {
+		return "", fmt.Errorf("list releases: %w", err)
+	}
+
+	releases := make([]string, 0, len(objects)+1)
+	for _, obj := range objects {
+		releases = append(releases, obj.Key)
 	}
-	p := s3.NewListObjectsV2Paginator(s3client, input)
-	for p.HasMorePages() {
-		page, err := p.NextPage(ctx)
-		if err != nil {
-			return "", fmt.Errorf("get next page: %w", err)
-		}
-		for _, obj := range page.Contents {
-			latest = obj
-		}
+	// The listing might have only older releases, e.g. the
+	// pre-releases of the current one's version
+	if channel.HasRelease(f.channel(), md.CurrRelease) {
+		releases = append(releases, md.CurrRelease)
 	}
 
-	if latest.Key == nil {
-		return md.CurrRelease, nil
+	if len(releases) == 0 {
+		return "", fmt.Errorf("no releases found for channel %q", f.channel())
 	}
-	return *latest.Key, nil
+	return slices.MaxFunc(releases, channel.CompareReleases), nil
 }
 
 func (f *Fetcher) DownloadRelease(ctx context.Context, release string) (io.ReadCloser, error) {
-	release = strings.TrimPrefix(release, prefix)
-	url, _ := url.JoinPath("https://releases.nixos.org/nixos/unstable", release, "options.json.br")
-
-	resp, err := http.Get(url)
+	url := channel.ReleaseURL(cmp.Or(f.Mirror, channel.ReleasesURL), release, "options.json.br")
+	body, err := httpclient.Get(ctx, cmp.Or(f.HTTPClient, http.DefaultClient), url)
 	if err != nil {
-		return nil, fmt.Errorf("fetch packages: %w", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("expected http 200, but %d", resp.StatusCode)
+		return nil, fmt.Errorf("fetch options: %w", err)
 	}
 
-	return readutil.PackagesWrapper(readutil.NewBrotli(resp.Body)), nil
+	return readutil.PackagesWrapper(readutil.NewBrotli(body)), nil
 }
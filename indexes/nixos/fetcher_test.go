@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
 	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
 
 	"github.com/alecthomas/assert/v2"
@@ -32,7 +33,7 @@ func TestFetcherOutput(t *testing.T) {
 	assert.NoError(t, err)
 	actualKeys := bytes.Buffer{}
 
-	err = indexer.Index(pkgsWrap, &actualKeys)
+	err = indexer.Index(pkgsWrap, jsonstream.Options{}, &actualKeys)
 	assert.NoError(t, err)
 
 	expectedLines := strings.Split(string(expectedKeys), "\n")
@@ -1,10 +1,12 @@
 package nixos
 
 import (
+	"cmp"
 	"fmt"
 	"io"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/channel"
 	"github.com/3timeslazy/nix-search-tv/indexes/textutil"
 	"github.com/3timeslazy/nix-search-tv/style"
 )
@@ -16,6 +18,10 @@ type Package struct {
 	Description  string   `json:"description"`
 	Declarations []string `json:"declarations"`
 	Default      Example  `json:"default"`
+
+	// Channel the option comes from, used for
+	// source links. Empty means nixos-unstable
+	Channel string `json:"-"`
 }
 
 type Example struct {
@@ -55,22 +61,29 @@ func (pkg *Package) Preview(out io.Writer) {
 }
 
 func (pkg *Package) GetSource() string {
+	ch := cmp.Or(pkg.Channel, channel.NixOSUnstable)
+
 	if len(pkg.Declarations) == 1 {
-		return fmt.Sprintf("https://github.com/NixOS/nixpkgs/blob/nixos-unstable/%s", pkg.Declarations[0])
+		return fmt.Sprintf("https://github.com/NixOS/nixpkgs/blob/%s/%s", ch, pkg.Declarations[0])
 	}
 
 	return fmt.Sprintf(
 		"https://search.nixos.org/options?"+
-			"channel=unstable"+
+			"channel=%[2]s"+
 			"&from=0&size=1"+
 			"&sort=relevances&query=%[1]s"+
 			// `show` automatically expands the package definion
 			// on the search page. Save users a click!
 			"&show=%[1]s",
 		pkg.Name,
+		channel.SearchName(ch),
 	)
 }
 
 func (pkg *Package) GetHomepage() string {
 	return pkg.GetSource()
 }
+
+func (pkg *Package) GetDescription() string {
+	return pkg.Description
+}
@@ -6,62 +6,92 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"strings"
+	"slices"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/channel"
 	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
+	"github.com/3timeslazy/nix-search-tv/pkgs/s3"
 )
 
-type Fetcher struct{}
+type Fetcher struct {
+	// Channel to fetch the packages of. Empty means nixpkgs-unstable
+	Channel string
+
+	// S3Endpoint is the URL of the nix-releases bucket
+	// or its mirror. Empty means the official bucket
+	S3Endpoint string
+
+	// Mirror replaces https://releases.nixos.org
+	// when downloading releases
+	Mirror string
+
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+func NewFetcher(ch string) *Fetcher {
+	return &Fetcher{Channel: ch}
+}
+
+func (f *Fetcher) channel() string {
+	return cmp.Or(f.Channel, channel.NixpkgsUnstable)
+}
+
+// ReleasePrefix implements indexer.ChannelFetcher
+func (f *Fetcher) ReleasePrefix() string {
+	return channel.S3Prefix(f.channel())
+}
 
 func (f *Fetcher) GetLatestRelease(ctx context.Context, md indexer.IndexMetadata) (string, error) {
-	s3client := s3.NewFromConfig(aws.Config{
-		Region: "eu-west-1",
-	})
+	s3client := &s3.Client{
+		BucketURL:  cmp.Or(f.S3Endpoint, channel.ReleasesBucketURL),
+		HTTPClient: f.HTTPClient,
+	}
 
 	// The `startAfter` is a marker for S3 to start iterating from. Just use the latest
 	// at the moment of writing nixpkgs release to never iterate from the beginning
-	startAfter := cmp.Or(md.CurrRelease, "nixpkgs/nixpkgs-25.05pre747523.95ea544c84eb")
-	var latest types.Object
-	input := &s3.ListObjectsV2Input{
-		Bucket:     aws.String("nix-releases"),
-		Prefix:     aws.String("nixpkgs/"),
-		Delimiter:  aws.String("/"),
-		StartAfter: aws.String(startAfter),
+	startAfter := ""
+	if f.channel() == channel.NixpkgsUnstable {
+		startAfter = "nixpkgs/nixpkgs-25.05pre747523.95ea544c84eb"
+	}
+	// The current release is of another channel if the channel has changed
+	if channel.ListAfter(f.channel(), md.CurrRelease) {
+		startAfter = md.CurrRelease
 	}
-	p := s3.NewListObjectsV2Paginator(s3client, input)
-	for p.HasMorePages() {
-		page, err := p.NextPage(ctx)
-		if err != nil {
-			return "", fmt.Errorf("get next page: %w", err)
-		}
-		for _, obj := range page.Contents {
-			latest = obj
-		}
+
+	objects, err := s3client.ListObjects(ctx, s3.ListInput{
+		Prefix:     f.ReleasePrefix(),
+		Delimiter:  "/",
+		StartAfter: startAfter,
+	})
+	if err != nil {
+		return "", fmt.Errorf("list releases: %w", err)
 	}
 
-	if latest.Key == nil {
-		return md.CurrRelease, nil
+	releases := make([]string, 0, len(objects)+1)
+	for _, obj := range objects {
+		releases = append(releases, obj.Key)
 	}
-	return *latest.Key, nil
+	// The listing might have only older releases, e.g. the
+	// pre-releases of the current one's version
+	if channel.HasRelease(f.channel(), md.CurrRelease) {
+		releases = append(releases, md.CurrRelease)
+	}
+
+	if len(releases) == 0 {
+		return "", fmt.Errorf("no releases found for channel %q", f.channel())
+	}
+	return slices.MaxFunc(releases, channel.CompareReleases), nil
 }
 
 func (f *Fetcher) DownloadRelease(ctx context.Context, release string) (io.ReadCloser, error) {
-	release = strings.TrimPrefix(release, "nixpkgs/")
-	url, _ := url.JoinPath("https://releases.nixos.org/nixpkgs", release, "packages.json.br")
-
-	resp, err := http.Get(url)
+	url := channel.ReleaseURL(cmp.Or(f.Mirror, channel.ReleasesURL), release, "packages.json.br")
+	body, err := httpclient.Get(ctx, cmp.Or(f.HTTPClient, http.DefaultClient), url)
 	if err != nil {
 		return nil, fmt.Errorf("fetch packages: %w", err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("expected http 200, but %d", resp.StatusCode)
-	}
 
-	return readutil.NewBrotli(resp.Body), nil
+	return readutil.NewBrotli(body), nil
 }
@@ -2,13 +2,18 @@ package nixpkgs
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"slices"
 	"strings"
 	"testing"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
 	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
 	"github.com/alecthomas/assert/v2"
 )
@@ -36,7 +41,7 @@ func TestFetcherOutput(t *testing.T) {
 	assert.NoError(t, err)
 	actualKeys := bytes.Buffer{}
 
-	err = indexer.Index(pkgsbr, &actualKeys)
+	err = indexer.Index(pkgsbr, jsonstream.Options{}, &actualKeys)
 	assert.NoError(t, err)
 
 	expectedLines := strings.Split(string(expectedKeys), "\n")
@@ -56,3 +61,74 @@ func TestFetcherOutput(t *testing.T) {
 		}
 	}
 }
+
+func TestGetLatestRelease(t *testing.T) {
+	startAfter := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startAfter = r.URL.Query().Get("start-after")
+		assert.Equal(t, "nixos/25.05/", r.URL.Query().Get("prefix"))
+
+		fmt.Fprint(w, `<ListBucketResult>
+  <Contents><Key>nixos/25.05/nixos-25.05.1.aaa</Key></Contents>
+  <Contents><Key>nixos/25.05/nixos-25.05.2.bbb</Key></Contents>
+  <IsTruncated>false</IsTruncated>
+</ListBucketResult>`)
+	}))
+	defer srv.Close()
+
+	fetcher := &Fetcher{Channel: "nixos-25.05", S3Endpoint: srv.URL}
+
+	// The release of another channel is not a valid marker
+	release, err := fetcher.GetLatestRelease(context.Background(), indexer.IndexMetadata{
+		CurrRelease: "nixpkgs/nixpkgs-25.11pre1.ccc",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "nixos/25.05/nixos-25.05.2.bbb", release)
+	assert.Equal(t, "", startAfter)
+
+	_, err = fetcher.GetLatestRelease(context.Background(), indexer.IndexMetadata{
+		CurrRelease: "nixos/25.05/nixos-25.05.1.aaa",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "nixos/25.05/nixos-25.05.1.aaa", startAfter)
+}
+
+func TestGetLatestReleaseBeta(t *testing.T) {
+	startAfter := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startAfter = r.URL.Query().Get("start-after")
+
+		// The keys are listed in the lexical order, where
+		// the pre-releases follow the final releases
+		fmt.Fprint(w, `<ListBucketResult>
+  <Contents><Key>nixos/25.05/nixos-25.05.802216.aaa</Key></Contents>
+  <Contents><Key>nixos/25.05/nixos-25.05.802300.bbb</Key></Contents>
+  <Contents><Key>nixos/25.05/nixos-25.05beta751650.ccc</Key></Contents>
+  <Contents><Key>nixos/25.05/nixos-25.05pre740000.ddd</Key></Contents>
+  <IsTruncated>false</IsTruncated>
+</ListBucketResult>`)
+	}))
+	defer srv.Close()
+
+	fetcher := &Fetcher{Channel: "nixos-25.05", S3Endpoint: srv.URL}
+
+	release, err := fetcher.GetLatestRelease(context.Background(), indexer.IndexMetadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, "nixos/25.05/nixos-25.05.802300.bbb", release)
+
+	// The final releases are listed before the beta, so not after it
+	release, err = fetcher.GetLatestRelease(context.Background(), indexer.IndexMetadata{
+		CurrRelease: "nixos/25.05/nixos-25.05beta751650.ccc",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "nixos/25.05/nixos-25.05.802300.bbb", release)
+	assert.Equal(t, "", startAfter)
+
+	// Nothing newer than the current one
+	release, err = fetcher.GetLatestRelease(context.Background(), indexer.IndexMetadata{
+		CurrRelease: "nixos/25.05/nixos-25.05.802300.bbb",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "nixos/25.05/nixos-25.05.802300.bbb", release)
+	assert.Equal(t, "nixos/25.05/nixos-25.05.802300.bbb", startAfter)
+}
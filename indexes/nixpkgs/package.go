@@ -13,6 +13,10 @@ type Package struct {
 	indexer.Package
 	Meta    Meta   `json:"meta"`
 	Version string `json:"version"`
+
+	// Branch of nixpkgs the package comes from,
+	// used for source links. Empty means nixos-unstable
+	Branch string `json:"-"`
 }
 
 type Meta struct {
@@ -6,6 +6,7 @@ import (
 	"io"
 	"strings"
 
+	"github.com/3timeslazy/nix-search-tv/indexes/channel"
 	"github.com/3timeslazy/nix-search-tv/indexes/textutil"
 	"github.com/3timeslazy/nix-search-tv/style"
 )
@@ -90,7 +91,16 @@ func (pkg *Package) GetSource() string {
 	}
 
 	src, _, _ = strings.Cut(src, ":")
-	return "https://github.com/NixOS/nixpkgs/blob/nixos-unstable/" + src
+	branch := cmp.Or(pkg.Branch, channel.NixOSUnstable)
+	return "https://github.com/NixOS/nixpkgs/blob/" + branch + "/" + src
+}
+
+func (pkg *Package) GetDescription() string {
+	return pkg.Meta.Description
+}
+
+func (pkg *Package) GetMainProgram() string {
+	return pkg.Meta.MainProgram
 }
 
 func (pkg *Package) GetHomepage() string {
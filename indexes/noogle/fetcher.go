@@ -1,29 +1,40 @@
 package noogle
 
 import (
-	"bytes"
 	"cmp"
 	"context"
 	"encoding/json"
+	"encoding/json/jsontext"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
 )
 
+// Fetcher keeps no state between the calls, so the same one can be used
+// for any number of updates. The price is that noogle returns the revision
+// and the data in a single response, which is therefore requested twice
+// when there is a new release
 type Fetcher struct {
-	// This is so far the first stateful fetcher. This is because
-	// noogle returns both "version" and "data" in a single HTTP request.
-	// To not make the same request twice, store it.
-	data NoogleFull
+	// Mirror is the URL of the noogle data to
+	// download instead of the official one
+	Mirror string
+
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
 }
 
-type NoogleFull struct {
-	Data         json.RawMessage   `json:"data"`
-	BuiltinTypes map[string]FnType `json:"builtinTypes"`
-	UpstreamInfo UpstreamInfo      `json:"upstreamInfo"`
+const dataURL = "https://noogle.dev/api/v1/data"
+
+// SchemaVersion implements indexer.SchemaFetcher. Bump
+// it whenever transformJSON changes the packages differently
+func (fetcher *Fetcher) SchemaVersion() int {
+	return 0
 }
 
 type UpstreamInfo struct {
@@ -34,56 +45,189 @@ type FnType struct {
 	FnType string `json:"fn_type"`
 }
 
-func (fetcher *Fetcher) GetLatestRelease(_ context.Context, _ indexer.IndexMetadata) (string, error) {
-	resp, err := http.Get("https://noogle.dev/api/v1/data")
+var errStopWalk = errors.New("stop walk")
+
+// GetLatestRelease returns the nixpkgs revision the data is built from
+func (fetcher *Fetcher) GetLatestRelease(ctx context.Context, _ indexer.IndexMetadata) (string, error) {
+	body, err := fetcher.download(ctx)
 	if err != nil {
-		return "", fmt.Errorf("fetch noogle data: %w", err)
+		return "", err
+	}
+	defer body.Close()
+
+	info := UpstreamInfo{}
+	err = walkRoot(body, func(key string, dec *jsontext.Decoder) error {
+		if key != "upstreamInfo" {
+			return dec.SkipValue()
+		}
+
+		raw, err := dec.ReadValue()
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, &info); err != nil {
+			return err
+		}
+		return errStopWalk
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return "", fmt.Errorf("parse noogle data: %w", err)
+	}
+	if info.Rev == "" {
+		return "", errors.New("parse noogle data: no upstream revision")
 	}
-	defer resp.Body.Close()
 
-	err = json.NewDecoder(resp.Body).Decode(&fetcher.data)
+	return info.Rev, nil
+}
+
+// DownloadRelease downloads the data again and transforms it while
+// it's being indexed. The release is the revision of nixpkgs set
+// to every package
+func (fetcher *Fetcher) DownloadRelease(ctx context.Context, release string) (io.ReadCloser, error) {
+	body, err := fetcher.download(ctx)
 	if err != nil {
-		return "", fmt.Errorf("parse noogle data: %w", err)
+		return nil, err
 	}
 
-	return fetcher.data.UpstreamInfo.Rev, nil
+	pkgs := readutil.StreamPackages(func(pw *readutil.PackagesWriter) error {
+		defer body.Close()
+
+		if err := transformJSON(body, release, pw); err != nil {
+			return fmt.Errorf("transform noogle data: %w", err)
+		}
+		return nil
+	})
+
+	return pkgs, nil
 }
 
-func (fetcher *Fetcher) DownloadRelease(_ context.Context, _ string) (io.ReadCloser, error) {
-	rd, err := transformJSON(bytes.NewReader(fetcher.data.Data), &fetcher.data)
+func (fetcher *Fetcher) download(ctx context.Context) (io.ReadCloser, error) {
+	client := cmp.Or(fetcher.HTTPClient, http.DefaultClient)
+	body, err := httpclient.Get(ctx, client, cmp.Or(fetcher.Mirror, dataURL))
 	if err != nil {
-		return nil, fmt.Errorf("transform noogle data: %w", err)
+		return nil, fmt.Errorf("fetch noogle data: %w", err)
 	}
 
-	return io.NopCloser(rd), nil
+	return body, nil
 }
 
-func transformJSON(r io.Reader, data *NoogleFull) (io.Reader, error) {
-	var srcPkgs []Package
-	if err := json.NewDecoder(r).Decode(&srcPkgs); err != nil {
-		return nil, fmt.Errorf("parse input json: %w", err)
+// transformJSON writes the packages of the noogle data one by one.
+//
+// Builtins without a signature take it from "builtinTypes", which
+// comes after "data" in the response. Only those few packages are
+// held back until the types are known
+func transformJSON(r io.Reader, rev string, pw *readutil.PackagesWriter) error {
+	var builtinTypes map[string]FnType
+	pending := []Package{}
+
+	write := func(pkg Package) error {
+		btype := builtinTypes[strings.TrimPrefix(pkg.Meta.Title, "builtins.")].FnType
+		pkg.Meta.Signature = cmp.Or(pkg.Meta.Signature, btype)
+		pkg.NixpkgsCommit = rev
+
+		return pw.Write(pkg.Meta.Title, pkg)
+	}
+
+	err := walkRoot(r, func(key string, dec *jsontext.Decoder) error {
+		switch key {
+		case "builtinTypes":
+			raw, err := dec.ReadValue()
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(raw, &builtinTypes); err != nil {
+				return fmt.Errorf("parse builtin types: %w", err)
+			}
+
+			for _, pkg := range pending {
+				if err := write(pkg); err != nil {
+					return err
+				}
+			}
+			pending = nil
+			return nil
+
+		case "data":
+			return walkArray(dec, func(raw []byte) error {
+				pkg := Package{}
+				if err := json.Unmarshal(raw, &pkg); err != nil {
+					return fmt.Errorf("parse package: %w", err)
+				}
+
+				isBuiltin := strings.HasPrefix(pkg.Meta.Title, "builtins.")
+				if builtinTypes == nil && isBuiltin && pkg.Meta.Signature == "" {
+					pending = append(pending, pkg)
+					return nil
+				}
+				return write(pkg)
+			})
+
+		default:
+			return dec.SkipValue()
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("parse input json: %w", err)
+	}
+
+	// No types at all, keep the
+	// builtins without signatures
+	for _, pkg := range pending {
+		if err := write(pkg); err != nil {
+			return err
+		}
 	}
 
-	pkgs := make(map[string]Package)
-	for _, item := range srcPkgs {
-		title := item.Meta.Title
+	return nil
+}
 
-		btype := data.BuiltinTypes[strings.TrimPrefix(title, "builtins.")].FnType
-		item.Meta.Signature = cmp.Or(item.Meta.Signature, btype)
-		item.NixpkgsCommit = data.UpstreamInfo.Rev
+// walkRoot calls fn with the decoder positioned at
+// the value of every key of the root object
+func walkRoot(r io.Reader, fn func(key string, dec *jsontext.Decoder) error) error {
+	dec := jsontext.NewDecoder(r)
 
-		pkgs[title] = item
+	t, err := dec.ReadToken()
+	if err != nil {
+		return err
+	}
+	if t.Kind() != '{' {
+		return fmt.Errorf("expected object, but got %s", t.Kind())
 	}
 
-	indexerPkgs := struct {
-		Packages map[string]Package `json:"packages"`
-	}{
-		Packages: pkgs,
+	for {
+		t, err := dec.ReadToken()
+		if err != nil {
+			return err
+		}
+		if t.Kind() == '}' {
+			return nil
+		}
+
+		if err := fn(t.String(), dec); err != nil {
+			return err
+		}
 	}
-	out, err := json.Marshal(indexerPkgs)
+}
+
+func walkArray(dec *jsontext.Decoder, fn func(raw []byte) error) error {
+	t, err := dec.ReadToken()
 	if err != nil {
-		return nil, fmt.Errorf("marshal noogle data for indexer: %w", err)
+		return err
+	}
+	if t.Kind() != '[' {
+		return fmt.Errorf("expected array, but got %s", t.Kind())
+	}
+
+	for dec.PeekKind() != ']' {
+		raw, err := dec.ReadValue()
+		if err != nil {
+			return err
+		}
+		if err := fn(raw); err != nil {
+			return err
+		}
 	}
 
-	return bytes.NewReader(out), nil
+	_, err = dec.ReadToken()
+	return err
 }
@@ -2,14 +2,19 @@ package noogle
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"slices"
 	"strings"
 	"testing"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
+	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
 	"github.com/alecthomas/assert/v2"
 )
 
@@ -21,20 +26,18 @@ import (
 func TestFetcherOutput(t *testing.T) {
 	t.Parallel()
 
-	full := loadTestdata(t)
-
 	indexer, err := indexer.NewBadger(indexer.BadgerConfig{InMemory: true})
 	assert.NoError(t, err)
 	defer indexer.Close()
 
-	rd, err := transformJSON(bytes.NewReader(full.Data), &full)
-	assert.NoError(t, err)
+	rd := transformTestdata(t, "rev")
+	defer rd.Close()
 
 	expectedKeys, err := os.ReadFile("./testdata/keys.txt")
 	assert.NoError(t, err)
 	actualKeys := bytes.Buffer{}
 
-	err = indexer.Index(io.NopCloser(rd), &actualKeys)
+	err = indexer.Index(rd, jsonstream.Options{}, &actualKeys)
 	assert.NoError(t, err)
 
 	expectedLines := strings.Split(string(expectedKeys), "\n")
@@ -60,20 +63,18 @@ func TestFetcherOutput(t *testing.T) {
 func TestSetsNixpkgsCommit(t *testing.T) {
 	t.Parallel()
 
-	full := loadTestdata(t)
-
-	rd, err := transformJSON(bytes.NewReader(full.Data), &full)
-	assert.NoError(t, err)
+	rd := transformTestdata(t, "abc123")
+	defer rd.Close()
 
 	var result struct {
 		Packages map[string]Package `json:"packages"`
 	}
-	err = json.NewDecoder(rd).Decode(&result)
+	err := json.NewDecoder(rd).Decode(&result)
 	assert.NoError(t, err)
 	assert.True(t, len(result.Packages) > 0)
 
 	for title, pkg := range result.Packages {
-		assert.Equal(t, full.UpstreamInfo.Rev, pkg.NixpkgsCommit, "NixpkgsCommit mismatch for %s", title)
+		assert.Equal(t, "abc123", pkg.NixpkgsCommit, "NixpkgsCommit mismatch for %s", title)
 	}
 }
 
@@ -83,15 +84,13 @@ func TestSetsNixpkgsCommit(t *testing.T) {
 func TestBuiltinSignatureFallback(t *testing.T) {
 	t.Parallel()
 
-	full := loadTestdata(t)
-
-	rd, err := transformJSON(bytes.NewReader(full.Data), &full)
-	assert.NoError(t, err)
+	rd := transformTestdata(t, "rev")
+	defer rd.Close()
 
 	var result struct {
 		Packages map[string]Package `json:"packages"`
 	}
-	err = json.NewDecoder(rd).Decode(&result)
+	err := json.NewDecoder(rd).Decode(&result)
 	assert.NoError(t, err)
 
 	expectedKeys, err := os.ReadFile("./testdata/signature_fallback.txt")
@@ -107,21 +106,62 @@ func TestBuiltinSignatureFallback(t *testing.T) {
 func TestInvalidJSON(t *testing.T) {
 	t.Parallel()
 
-	_, err := transformJSON(strings.NewReader("not json"), &NoogleFull{})
+	rd := readutil.StreamPackages(func(pw *readutil.PackagesWriter) error {
+		return transformJSON(strings.NewReader("not json"), "rev", pw)
+	})
+	defer rd.Close()
+
+	_, err := io.ReadAll(rd)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "parse input json")
 }
 
-func loadTestdata(t *testing.T) NoogleFull {
+func transformTestdata(t *testing.T, rev string) io.ReadCloser {
 	t.Helper()
 
 	f, err := os.Open("./testdata/noogle.json")
 	assert.NoError(t, err)
-	defer f.Close()
+	t.Cleanup(func() { f.Close() })
+
+	return readutil.StreamPackages(func(pw *readutil.PackagesWriter) error {
+		return transformJSON(f, rev, pw)
+	})
+}
+
+// TestFetcherReuse verifies that the same fetcher gets the revision
+// and downloads the packages any number of times
+func TestFetcherReuse(t *testing.T) {
+	t.Parallel()
 
-	var full NoogleFull
-	err = json.NewDecoder(f).Decode(&full)
+	data, err := os.ReadFile("./testdata/noogle.json")
 	assert.NoError(t, err)
 
-	return full
+	var upstream struct {
+		UpstreamInfo UpstreamInfo `json:"upstreamInfo"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &upstream))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	fetcher := &Fetcher{Mirror: srv.URL}
+	for range 2 {
+		rev, err := fetcher.GetLatestRelease(context.Background(), indexer.IndexMetadata{})
+		assert.NoError(t, err)
+		assert.Equal(t, upstream.UpstreamInfo.Rev, rev)
+
+		rd, err := fetcher.DownloadRelease(context.Background(), rev)
+		assert.NoError(t, err)
+
+		idx, err := indexer.NewBadger(indexer.BadgerConfig{InMemory: true})
+		assert.NoError(t, err)
+
+		keys := bytes.Buffer{}
+		assert.NoError(t, idx.Index(rd, jsonstream.Options{}, &keys))
+		assert.NoError(t, rd.Close())
+		assert.NoError(t, idx.Close())
+		assert.True(t, keys.Len() > 0)
+	}
 }
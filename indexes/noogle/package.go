@@ -93,6 +93,16 @@ func (pkg *Package) GetSource() string {
 	return pkg.GetHomepage()
 }
 
+// GetDescription returns the first paragraph of the docs
+func (pkg *Package) GetDescription() string {
+	if pkg.Content == nil {
+		return ""
+	}
+
+	desc, _, _ := strings.Cut(strings.TrimSpace(pkg.Content.Content), "\n\n")
+	return strings.Join(strings.Fields(desc), " ")
+}
+
 func (pkg *Package) GetHomepage() string {
 	path := strings.ReplaceAll(pkg.Meta.Title, ".", "/")
 	return fmt.Sprintf("https://noogle.dev/f/%s", path)
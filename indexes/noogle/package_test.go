@@ -117,7 +117,10 @@ func loadNooglePkgs(t *testing.T) (map[string]Package, string) {
 	data, err := os.ReadFile("./testdata/noogle.json")
 	assert.NoError(t, err)
 
-	noogle := NoogleFull{}
+	var noogle struct {
+		Data         json.RawMessage `json:"data"`
+		UpstreamInfo UpstreamInfo    `json:"upstreamInfo"`
+	}
 	err = json.Unmarshal(data, &noogle)
 	assert.NoError(t, err)
 
@@ -1,59 +1,138 @@
 package nur
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
 	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
 )
 
-type Fetcher struct{}
+type Fetcher struct {
+	// Mirror replaces both GitHub URLs of nur-search. It must serve
+	// the latest commit at <mirror>/commits in the GitHub API format,
+	// i.e. [{"sha": "..."}], and the packages at <mirror>/<sha>/data/packages.json
+	Mirror string
+
+	// Token authenticates the requests to the GitHub API, which raises
+	// the rate limit. Defaults to the GITHUB_TOKEN variable
+	Token string
+
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
 
 const commitsURL = "https://api.github.com/repos/nix-community/nur-search/commits?page=1&per_page=1"
 
 func (f *Fetcher) GetLatestRelease(ctx context.Context, md indexer.IndexMetadata) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, commitsURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("create request: %w", err)
+	release, _, err := f.GetLatestReleaseETag(ctx, md)
+	return release, err
+}
+
+// GetLatestReleaseETag returns the sha of the latest nur-search commit and
+// the ETag of the GitHub response, so that the next check can be conditional.
+// Responses with 304 do not count against the GitHub rate limit
+func (f *Fetcher) GetLatestReleaseETag(ctx context.Context, md indexer.IndexMetadata) (string, string, error) {
+	url := commitsURL
+	if f.Mirror != "" {
+		url = strings.TrimSuffix(f.Mirror, "/") + "/commits?page=1&per_page=1"
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.github+json")
+	// Never send the token anywhere but GitHub
+	if token := cmp.Or(f.Token, os.Getenv("GITHUB_TOKEN")); token != "" && f.Mirror == "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	if md.ReleaseETag != "" {
+		header.Set("If-None-Match", md.ReleaseETag)
+	}
+
+	resp, err := httpclient.GetResponse(ctx, cmp.Or(f.HTTPClient, http.DefaultClient), url, header)
+	if statusErr := (*httpclient.StatusError)(nil); errors.As(err, &statusErr) {
+		if err := rateLimitError(statusErr); err != nil {
+			return "", "", err
+		}
+		return "", "", fmt.Errorf("unexpected github response: http %d", statusErr.StatusCode)
+	}
 	if err != nil {
-		return "", fmt.Errorf("github request failed: %w", err)
+		return "", "", fmt.Errorf("github request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return md.CurrRelease, md.ReleaseETag, nil
+	}
+
 	commits := []struct {
 		Sha string `json:"sha"`
 	}{}
 	err = json.NewDecoder(resp.Body).Decode(&commits)
 	if err != nil {
-		return "", fmt.Errorf("failed to unmarshal github response: %w", err)
+		return "", "", fmt.Errorf("failed to unmarshal github response: %w", err)
 	}
 
 	if len(commits) < 1 {
-		return "", fmt.Errorf("unexpected result from github: %w", err)
+		return "", "", errors.New("unexpected result from github: no commits")
+	}
+
+	return commits[0].Sha, resp.Header.Get("ETag"), nil
+}
+
+// rateLimitError returns an error if GitHub responded with the rate limit
+// exceeded, and nil otherwise, e.g. for 403 of a wrong token. If GitHub
+// tells when the limit resets, the error is indexer.RetryLaterError, so
+// that the index is not checked again before that
+func rateLimitError(resp *httpclient.StatusError) error {
+	limited := resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusForbidden &&
+			(resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != "")
+	if !limited {
+		return nil
+	}
+
+	var reset time.Time
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		reset = time.Now().Add(time.Duration(secs) * time.Second)
+	} else if unix, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		reset = time.Unix(unix, 0)
+	}
+
+	if reset.IsZero() {
+		return errors.New("github rate limit exceeded, set GITHUB_TOKEN to raise it")
+	}
+	return &indexer.RetryLaterError{
+		Err: fmt.Errorf(
+			"github rate limit exceeded until %s, set GITHUB_TOKEN to raise it",
+			reset.Format(time.TimeOnly),
+		),
+		At: reset,
 	}
-	return commits[0].Sha, nil
 }
 
 const packagesURL = "https://raw.githubusercontent.com/nix-community/nur-search/%s/data/packages.json"
 
 func (f *Fetcher) DownloadRelease(ctx context.Context, release string) (io.ReadCloser, error) {
 	apiurl := fmt.Sprintf(packagesURL, release)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiurl, nil)
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
+	if f.Mirror != "" {
+		apiurl = strings.TrimSuffix(f.Mirror, "/") + "/" + release + "/data/packages.json"
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	body, err := httpclient.Get(ctx, cmp.Or(f.HTTPClient, http.DefaultClient), apiurl)
 	if err != nil {
-		return nil, fmt.Errorf("github request failed: %w", err)
+		return nil, fmt.Errorf("download packages: %w", err)
 	}
 
-	return readutil.PackagesWrapper(resp.Body), nil
+	return readutil.PackagesWrapper(body), nil
 }
@@ -2,13 +2,20 @@ package nur
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
+	"github.com/3timeslazy/nix-search-tv/indexer/jsonstream"
 	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
 
 	"github.com/alecthomas/assert/v2"
@@ -32,7 +39,7 @@ func TestFetcherOutput(t *testing.T) {
 	assert.NoError(t, err)
 	actualKeys := bytes.Buffer{}
 
-	err = indexer.Index(pkgsbr, &actualKeys)
+	err = indexer.Index(pkgsbr, jsonstream.Options{}, &actualKeys)
 	assert.NoError(t, err)
 
 	expectedLines := strings.Split(string(expectedKeys), "\n")
@@ -52,3 +59,114 @@ func TestFetcherOutput(t *testing.T) {
 		}
 	}
 }
+
+func TestFetcherMirror(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nur/commits", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"sha": "abc"}]`))
+	})
+	mux.HandleFunc("/nur/abc/data/packages.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hello": {}}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	fetcher := &Fetcher{Mirror: srv.URL + "/nur/"}
+
+	release, err := fetcher.GetLatestRelease(context.Background(), indexer.IndexMetadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", release)
+
+	rd, err := fetcher.DownloadRelease(context.Background(), release)
+	assert.NoError(t, err)
+	defer rd.Close()
+
+	data, err := io.ReadAll(rd)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"packages":{"hello": {}}}`, string(data))
+}
+
+type roundTripFunc func(*http.Request) *http.Response
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+func githubResponse(status int, header http.Header, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestGetLatestReleaseGitHub(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("conditional request with token", func(t *testing.T) {
+		fetcher := &Fetcher{
+			Token: "secret",
+			HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+
+				switch req.Header.Get("If-None-Match") {
+				case `"v1"`:
+					return githubResponse(http.StatusNotModified, http.Header{}, "")
+				case `"old"`:
+					return githubResponse(http.StatusOK, http.Header{"Etag": {`"v2"`}}, `[{"sha": "abc"}]`)
+				}
+				return githubResponse(http.StatusOK, http.Header{"Etag": {`"v1"`}}, `[{"sha": "abc"}]`)
+			})},
+		}
+
+		release, etag, err := fetcher.GetLatestReleaseETag(ctx, indexer.IndexMetadata{})
+		assert.NoError(t, err)
+		assert.Equal(t, "abc", release)
+		assert.Equal(t, `"v1"`, etag)
+
+		md := indexer.IndexMetadata{CurrRelease: release, ReleaseETag: etag}
+		release, etag, err = fetcher.GetLatestReleaseETag(ctx, md)
+		assert.NoError(t, err)
+		assert.Equal(t, "abc", release)
+		assert.Equal(t, `"v1"`, etag)
+
+		// The same commit, but the ETag has changed since
+		md = indexer.IndexMetadata{CurrRelease: "abc", ReleaseETag: `"old"`}
+		release, etag, err = fetcher.GetLatestReleaseETag(ctx, md)
+		assert.NoError(t, err)
+		assert.Equal(t, "abc", release)
+		assert.Equal(t, `"v2"`, etag)
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		fetcher := &Fetcher{
+			HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				return githubResponse(http.StatusForbidden, http.Header{
+					"X-Ratelimit-Remaining": {"0"},
+					"X-Ratelimit-Reset":     {"1700000000"},
+				}, `{"message": "API rate limit exceeded"}`)
+			})},
+		}
+
+		// Not a successful check even with the current index,
+		// but the next one is postponed until the reset
+		_, err := fetcher.GetLatestRelease(ctx, indexer.IndexMetadata{CurrRelease: "abc"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "github rate limit exceeded until")
+
+		retry := &indexer.RetryLaterError{}
+		assert.True(t, errors.As(err, &retry))
+		assert.Equal(t, time.Unix(1700000000, 0), retry.At)
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		fetcher := &Fetcher{
+			HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				return githubResponse(http.StatusForbidden, http.Header{}, `{"message": "Bad credentials"}`)
+			})},
+		}
+
+		_, err := fetcher.GetLatestRelease(ctx, indexer.IndexMetadata{CurrRelease: "abc"})
+		assert.EqualError(t, err, "unexpected github response: http 403")
+	})
+}
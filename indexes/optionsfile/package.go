@@ -61,6 +61,10 @@ func (pkg *Package) GetHomepage() string {
 	return pkg.GetSource()
 }
 
+func (pkg *Package) GetDescription() string {
+	return pkg.Description
+}
+
 // String is type that can be decoded from either a string, or an object with
 // certain fields often used in options.json files e.g. text, url
 type String string
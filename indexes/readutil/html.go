@@ -0,0 +1,29 @@
+package readutil
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
+)
+
+// OpenPage opens the HTML page of the release. A file:// release is the
+// path of a local copy of the page, otherwise the page is downloaded
+// from the URL with the given client, which defaults to http.DefaultClient,
+// unless the cache has kept it while checking the version
+func OpenPage(
+	ctx context.Context,
+	client *http.Client,
+	cache *httpclient.ContentCache,
+	release, url string,
+) (io.ReadCloser, error) {
+	if _, path, ok := strings.Cut(release, "file://"); ok {
+		return os.Open(path)
+	}
+
+	return cache.Get(ctx, cmp.Or(client, http.DefaultClient), url, release)
+}
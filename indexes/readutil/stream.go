@@ -0,0 +1,76 @@
+package readutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// PackagesWriter writes packages in the format of the indexer
+// one at a time, see PackagesWrapper for the format
+type PackagesWriter struct {
+	w    *bufio.Writer
+	n    int
+	seen map[string]struct{}
+}
+
+// Write encodes the package and writes it under the name. The indexer
+// rejects duplicate names, so only the first package with a name is kept.
+//
+// Note that it's the first one, not the last one as with collecting the
+// packages into a map: by the time a duplicate comes, the first one is
+// already written, and holding them all back is what streaming avoids
+func (pw *PackagesWriter) Write(name string, pkg any) error {
+	if _, ok := pw.seen[name]; ok {
+		return nil
+	}
+	pw.seen[name] = struct{}{}
+
+	key, err := json.Marshal(name)
+	if err != nil {
+		return fmt.Errorf("encode name %q: %w", name, err)
+	}
+	content, err := json.Marshal(pkg)
+	if err != nil {
+		return fmt.Errorf("encode %q: %w", name, err)
+	}
+
+	if pw.n > 0 {
+		pw.w.WriteByte(',')
+	}
+	pw.n++
+
+	pw.w.Write(key)
+	pw.w.WriteByte(':')
+	_, err = pw.w.Write(content)
+	return err
+}
+
+// StreamPackages runs write in a goroutine and returns what it writes
+// as it's being written. Closing the reader makes the next writes fail,
+// so write must stop on the first error.
+//
+// Unlike encoding a map of all the packages, it keeps only a
+// small buffer of them in memory
+func StreamPackages(write func(pw *PackagesWriter) error) io.ReadCloser {
+	pr, pipe := io.Pipe()
+
+	go func() {
+		pw := &PackagesWriter{
+			w:    bufio.NewWriterSize(pipe, 64<<10),
+			seen: map[string]struct{}{},
+		}
+
+		pw.w.WriteString(`{"packages":{`)
+		err := write(pw)
+		if err == nil {
+			pw.w.WriteString(`}}`)
+			err = pw.w.Flush()
+		}
+
+		pipe.CloseWithError(err)
+	}()
+
+	return pr
+}
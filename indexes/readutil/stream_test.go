@@ -0,0 +1,43 @@
+package readutil
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestStreamPackages(t *testing.T) {
+	rd := StreamPackages(func(pw *PackagesWriter) error {
+		for _, pkg := range []struct {
+			name string
+			v    int
+		}{{"a", 1}, {"b", 2}, {"a", 3}} {
+			if err := pw.Write(pkg.name, map[string]int{"v": pkg.v}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer rd.Close()
+
+	data, err := io.ReadAll(rd)
+	assert.NoError(t, err)
+
+	// The first "a" wins
+	assert.Equal(t, `{"packages":{"a":{"v":1},"b":{"v":2}}}`, string(data))
+}
+
+func TestStreamPackagesError(t *testing.T) {
+	rd := StreamPackages(func(pw *PackagesWriter) error {
+		if err := pw.Write("a", 1); err != nil {
+			return err
+		}
+		return errors.New("broken page")
+	})
+	defer rd.Close()
+
+	_, err := io.ReadAll(rd)
+	assert.EqualError(t, err, "broken page")
+}
@@ -1,20 +1,16 @@
 package renderdocs
 
 import (
-	"bytes"
+	"cmp"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"strings"
-	"time"
+	"net/http"
 
 	"github.com/3timeslazy/nix-search-tv/indexer"
 	"github.com/3timeslazy/nix-search-tv/indexes/readutil"
+	"github.com/3timeslazy/nix-search-tv/pkgs/httpclient"
 	"github.com/3timeslazy/nix-search-tv/pkgs/renderdocs"
-
-	"github.com/antchfx/htmlquery"
-	"golang.org/x/net/html"
 )
 
 type Package struct {
@@ -29,6 +25,11 @@ type Package struct {
 
 type Fetcher struct {
 	url string
+
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+
+	page httpclient.ContentCache
 }
 
 func NewFetcher(url string) *Fetcher {
@@ -37,50 +38,37 @@ func NewFetcher(url string) *Fetcher {
 	}
 }
 
-func (f *Fetcher) GetLatestRelease(_ context.Context, _ indexer.IndexMetadata) (string, error) {
-	return time.Now().String(), nil
+// GetLatestRelease returns the version of the page, so that
+// unchanged pages are not downloaded and indexed again
+func (f *Fetcher) GetLatestRelease(ctx context.Context, md indexer.IndexMetadata) (string, error) {
+	client := cmp.Or(f.HTTPClient, http.DefaultClient)
+	return f.page.Version(ctx, client, f.url, md.CurrRelease)
 }
 
-func (f *Fetcher) DownloadRelease(_ context.Context, release string) (io.ReadCloser, error) {
-	var doc *html.Node
-	var err error
-
-	_, path, ok := strings.Cut(release, "file://")
-	if ok {
-		doc, err = htmlquery.LoadDoc(path)
-	} else {
-		doc, err = htmlquery.LoadURL(f.url)
-	}
+func (f *Fetcher) DownloadRelease(ctx context.Context, release string) (io.ReadCloser, error) {
+	page, err := readutil.OpenPage(ctx, f.HTTPClient, &f.page, release, f.url)
 	if err != nil {
-		return nil, fmt.Errorf("download options.xhtml: %w", err)
+		return nil, fmt.Errorf("download %s: %w", f.url, err)
 	}
 
-	htmlPkgs, err := renderdocs.Parse(doc)
-	if err != nil {
-		return nil, fmt.Errorf("parse options.xhtml: %w", err)
-	}
+	return readutil.StreamPackages(func(pw *readutil.PackagesWriter) error {
+		defer page.Close()
 
-	pkgs := map[string]Package{}
-	for name, htmlPkg := range htmlPkgs {
-		pkg := Package{
-			Package: indexer.Package{
-				Name: htmlPkg.Name,
-			},
-			Example:     htmlPkg.Example,
-			Type:        htmlPkg.Type,
-			Description: htmlPkg.Description,
-			Default:     htmlPkg.Default,
-			DeclaredBy:  htmlPkg.DeclaredBy,
+		err := renderdocs.WalkReader(page, func(htmlPkg renderdocs.Package) error {
+			return pw.Write(htmlPkg.Name, Package{
+				Package: indexer.Package{
+					Name: htmlPkg.Name,
+				},
+				Example:     htmlPkg.Example,
+				Type:        htmlPkg.Type,
+				Description: htmlPkg.Description,
+				Default:     htmlPkg.Default,
+				DeclaredBy:  htmlPkg.DeclaredBy,
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("parse page: %w", err)
 		}
-
-		pkgs[name] = pkg
-	}
-
-	buf := &bytes.Buffer{}
-	err = json.NewEncoder(buf).Encode(pkgs)
-	if err != nil {
-		return nil, fmt.Errorf("encode json: %w", err)
-	}
-
-	return readutil.PackagesWrapper(io.NopCloser(buf)), nil
+		return nil
+	}), nil
 }
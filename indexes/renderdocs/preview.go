@@ -56,3 +56,7 @@ func (pkg *Package) GetSource() string {
 func (pkg *Package) GetHomepage() string {
 	return pkg.GetSource()
 }
+
+func (pkg *Package) GetDescription() string {
+	return textutil.StripHTML(pkg.Description)
+}
@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/3timeslazy/nix-search-tv/style"
+
+	"golang.org/x/net/html"
 )
 
 var s = style.TextStyle
@@ -87,3 +89,23 @@ func currentPlatform() string {
 	kern := runtime.GOOS
 	return arch + "-" + kern
 }
+
+// StripHTML returns the text content of an HTML fragment
+// with whitespace collapsed
+func StripHTML(text string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(text))
+
+	sb := strings.Builder{}
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(sb.String()), " ")
+
+		case html.TextToken:
+			sb.Write(tokenizer.Text())
+
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			sb.WriteByte(' ')
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package fuzzy implements a small fuzzy matcher for ranking package
+// names without an external fuzzy finder
+package fuzzy
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The scores are chosen so that any exact match beats any prefix
+// match, which beats any substring match, which beats any subsequence match
+const (
+	scoreExact       = 1000
+	scorePrefix      = 800
+	scoreSubstring   = 600
+	scoreSubsequence = 400
+
+	bonusBoundary    = 30
+	bonusConsecutive = 10
+	penaltyGap       = 3
+	maxLenPenalty    = 150
+)
+
+// Score returns how well the pattern matches the text, and whether it
+// matches at all. Matching is case-insensitive and all the characters of
+// the pattern must appear in the text in the same order.
+//
+// Shorter texts, matches at word boundaries and consecutive characters
+// score higher
+func Score(pattern, text string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+
+	p := strings.ToLower(pattern)
+	t := strings.ToLower(text)
+
+	if t == p {
+		return scoreExact, true
+	}
+	if strings.HasPrefix(t, p) {
+		return scorePrefix - lenPenalty(p, t), true
+	}
+	if i := strings.Index(t, p); i >= 0 {
+		score := scoreSubstring - lenPenalty(p, t)
+		if isBoundary(t, i) {
+			score += bonusBoundary
+		}
+		return score, true
+	}
+
+	return subsequence(p, t)
+}
+
+func subsequence(p, t string) (int, bool) {
+	score := 0
+	// Where the previous matched character ends
+	prevEnd := -1
+	ti := 0
+
+	for _, pr := range p {
+		found := false
+		for ti < len(t) {
+			tr, size := utf8.DecodeRuneInString(t[ti:])
+			if tr != pr {
+				ti += size
+				continue
+			}
+
+			switch {
+			case ti == prevEnd:
+				score += bonusConsecutive
+			case isBoundary(t, ti):
+				score += bonusBoundary
+			case prevEnd >= 0:
+				score -= penaltyGap * min(ti-prevEnd, 10)
+			}
+
+			ti += size
+			prevEnd = ti
+			found = true
+			break
+		}
+		if !found {
+			return 0, false
+		}
+	}
+
+	score = scoreSubsequence + score - lenPenalty(p, t)
+
+	// Keep the score order described above
+	return min(score, scoreSubstring-maxLenPenalty-1), true
+}
+
+func lenPenalty(p, t string) int {
+	return min(len(t)-len(p), maxLenPenalty)
+}
+
+func isBoundary(t string, i int) bool {
+	if i == 0 {
+		return true
+	}
+
+	switch t[i-1] {
+	case '.', '-', '_', '/', ' ':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package fuzzy
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestScore(t *testing.T) {
+	t.Run("no match", func(t *testing.T) {
+		_, ok := Score("gitx", "lazygit")
+		assert.False(t, ok)
+	})
+
+	t.Run("case insensitive", func(t *testing.T) {
+		_, ok := Score("FireFox", "firefox")
+		assert.True(t, ok)
+	})
+
+	t.Run("ranking", func(t *testing.T) {
+		texts := []string{
+			"python312Packages.gitpython",
+			"gitg",
+			"git",
+			"lazygit",
+			"gnome-integration-tools",
+			"git-absorb",
+		}
+
+		slices.SortStableFunc(texts, func(a, b string) int {
+			sa, _ := Score("git", a)
+			sb, _ := Score("git", b)
+			return sb - sa
+		})
+
+		expected := []string{
+			"git",
+			"gitg",
+			"git-absorb",
+			"python312Packages.gitpython",
+			"lazygit",
+			"gnome-integration-tools",
+		}
+		assert.Equal(t, expected, texts)
+	})
+}
@@ -0,0 +1,423 @@
+// Package httpclient builds the HTTP client shared by all the fetchers.
+// On top of the standard client, it retries failed requests, sets the
+// User-Agent and aborts downloads that have stalled
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	// ConnectTimeout limits dialing and the TLS handshake
+	ConnectTimeout time.Duration
+
+	// ReadTimeout limits waiting for the response headers and for every
+	// next chunk of the body. Unlike http.Client.Timeout, it never cuts
+	// big downloads as long as the data keeps coming
+	ReadTimeout time.Duration
+
+	// Retries is how many times to retry a request that
+	// failed with a network error, 429 or 5xx status
+	Retries int
+
+	// Proxy is the URL of the proxy to use instead of
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables
+	Proxy string
+
+	// CAFiles are PEM bundles of certificates to
+	// trust in addition to the system ones
+	CAFiles []string
+
+	UserAgent string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ConnectTimeout: 10 * time.Second,
+		ReadTimeout:    30 * time.Second,
+		Retries:        3,
+		UserAgent:      DefaultUserAgent(),
+	}
+}
+
+func DefaultUserAgent() string {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+
+	return "nix-search-tv/" + version + " (+https://github.com/3timeslazy/nix-search-tv)"
+}
+
+var ErrReadTimeout = errors.New("read timeout")
+
+// retryDelay is the delay before the first retry,
+// doubled after every next one
+var retryDelay = 500 * time.Millisecond
+
+func New(conf Config) (*http.Client, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+
+	dialer := &net.Dialer{
+		Timeout:   conf.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	base.DialContext = dialer.DialContext
+	base.TLSHandshakeTimeout = conf.ConnectTimeout
+	base.ResponseHeaderTimeout = conf.ReadTimeout
+
+	if conf.Proxy != "" {
+		proxy, err := url.Parse(conf.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", conf.Proxy)
+		}
+		base.Proxy = http.ProxyURL(proxy)
+	}
+
+	if len(conf.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		for _, path := range conf.CAFiles {
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("read CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %q", path)
+			}
+		}
+
+		base.TLSClientConfig = &tls.Config{
+			RootCAs: pool,
+		}
+	}
+
+	return &http.Client{
+		Transport: &transport{
+			base: base,
+			conf: conf,
+		},
+	}, nil
+}
+
+// Get requests the URL and fails if the response is not 200
+func Get(ctx context.Context, client *http.Client, url string) (io.ReadCloser, error) {
+	resp, err := GetResponse(ctx, client, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &StatusError{StatusCode: resp.StatusCode, Header: resp.Header}
+	}
+
+	return resp.Body, nil
+}
+
+// GetResponse is Get for when the response headers matter or the request
+// needs headers of its own. Besides 200, it returns 304 as well, which
+// is what conditional requests get for unchanged content
+func GetResponse(ctx context.Context, client *http.Client, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+		resp.Body.Close()
+		return nil, &StatusError{StatusCode: resp.StatusCode, Header: resp.Header}
+	}
+
+	return resp, nil
+}
+
+// StatusError is returned for unexpected response statuses. The
+// header is kept for the callers that look into it, e.g. for rate limits
+type StatusError struct {
+	StatusCode int
+	Header     http.Header
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("expected http 200, but %d", e.StatusCode)
+}
+
+type transport struct {
+	base http.RoundTripper
+	conf Config
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.conf.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.conf.UserAgent)
+	}
+
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		resp, err := t.roundTrip(req)
+		if attempt >= t.conf.Retries || !shouldRetry(req, resp, err) {
+			if n, ok := req.Context().Value(bytesCounterKey{}).(*atomic.Int64); ok && err == nil {
+				resp.Body = &countingBody{body: resp.Body, n: n}
+			}
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		delay *= 2
+	}
+}
+
+func (t *transport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.conf.ReadTimeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = newTimeoutBody(resp.Body, t.conf.ReadTimeout, cancel)
+	return resp, nil
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	// Requests with a body can't be replayed
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// timeoutBody cancels the request once a single read
+// of the body takes longer than the timeout
+type timeoutBody struct {
+	body     io.ReadCloser
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut atomic.Bool
+	cancel   context.CancelFunc
+}
+
+func newTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *timeoutBody {
+	b := &timeoutBody{
+		body:    body,
+		timeout: timeout,
+		cancel:  cancel,
+	}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.timedOut.Store(true)
+		cancel()
+	})
+	// Only the time spent in Read counts, so
+	// that slow consumers do not time out
+	b.timer.Stop()
+
+	return b
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.body.Read(p)
+	b.timer.Stop()
+
+	if err != nil && b.timedOut.Load() {
+		err = ErrReadTimeout
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.body.Close()
+}
+
+type bytesCounterKey struct{}
+
+// WithBytesCounter makes the bodies of the responses to the requests
+// made with the context add their sizes to n as they are read.
+//
+// The sizes are of what came over the network, e.g. before unpacking
+// a .br file. The only exception is the gzip encoding that net/http
+// decodes on its own, which is counted decoded
+func WithBytesCounter(ctx context.Context, n *atomic.Int64) context.Context {
+	return context.WithValue(ctx, bytesCounterKey{}, n)
+}
+
+type countingBody struct {
+	body io.ReadCloser
+	n    *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	return b.body.Close()
+}
+
+// ContentVersion returns an identifier of the current content of the URL.
+// It is the ETag of the response, or its Last-Modified, or, if the server
+// sets neither, the sha256 of the body.
+//
+// prev is the version returned before. It makes the request conditional.
+// The headers come from a HEAD request, so the body is only downloaded
+// for the hash. ContentCache keeps it for the download that follows
+func ContentVersion(ctx context.Context, client *http.Client, url, prev string) (string, error) {
+	version, _, err := contentVersion(ctx, client, url, prev)
+	return version, err
+}
+
+// contentVersion is ContentVersion that also returns the
+// body if it had to be downloaded for the hash
+func contentVersion(ctx context.Context, client *http.Client, url, prev string) (string, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("create request: %w", err)
+	}
+
+	if etag, ok := strings.CutPrefix(prev, etagVersion); ok {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if modified, ok := strings.CutPrefix(prev, lastModifiedVersion); ok {
+		req.Header.Set("If-Modified-Since", modified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return prev, nil, nil
+	case http.StatusOK:
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			return etagVersion + etag, nil, nil
+		}
+		if modified := resp.Header.Get("Last-Modified"); modified != "" {
+			return lastModifiedVersion + modified, nil, nil
+		}
+	}
+
+	// Either there is nothing but the body to tell the version by,
+	// or the server does not support HEAD. The GET tells which
+	body, err := Get(ctx, client, url)
+	if err != nil {
+		return "", nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", nil, fmt.Errorf("read body: %w", err)
+	}
+	hash := sha256.Sum256(data)
+
+	return sha256Version + hex.EncodeToString(hash[:]), data, nil
+}
+
+// ContentCache keeps the body that ContentVersion had to download for
+// the hash, so that downloading the same version does not request it
+// again. The zero value is ready to use
+type ContentCache struct {
+	mu      sync.Mutex
+	url     string
+	version string
+	body    []byte
+}
+
+// Version is ContentVersion that keeps the downloaded body
+func (c *ContentCache) Version(ctx context.Context, client *http.Client, url, prev string) (string, error) {
+	version, body, err := contentVersion(ctx, client, url, prev)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.url, c.version, c.body = url, version, body
+
+	return version, nil
+}
+
+// Get is Get that returns the kept body instead if it is of the version.
+// The body is only kept until then, and counts for WithBytesCounter
+// as if it was downloaded now
+func (c *ContentCache) Get(ctx context.Context, client *http.Client, url, version string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	body := c.body
+	if c.url != url || c.version != version {
+		body = nil
+	}
+	c.url, c.version, c.body = "", "", nil
+	c.mu.Unlock()
+
+	if body == nil {
+		return Get(ctx, client, url)
+	}
+
+	if n, ok := ctx.Value(bytesCounterKey{}).(*atomic.Int64); ok {
+		n.Add(int64(len(body)))
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+const (
+	etagVersion         = "etag:"
+	lastModifiedVersion = "last-modified:"
+	sha256Version       = "sha256:"
+)
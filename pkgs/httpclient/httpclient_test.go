@@ -0,0 +1,260 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func init() {
+	retryDelay = time.Millisecond
+}
+
+func TestRetries(t *testing.T) {
+	attempts := atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-agent", r.UserAgent())
+
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client, err := New(Config{Retries: 2, UserAgent: "test-agent"})
+	assert.NoError(t, err)
+
+	body, err := Get(context.Background(), client, srv.URL)
+	assert.NoError(t, err)
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(data))
+	assert.Equal(t, int32(3), attempts.Load())
+}
+
+func TestGetResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("If-None-Match") {
+		case `"v1"`:
+			w.WriteHeader(http.StatusNotModified)
+		case "":
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+
+	client, err := New(Config{})
+	assert.NoError(t, err)
+
+	resp, err := GetResponse(context.Background(), client, srv.URL, http.Header{"If-None-Match": {`"v1"`}})
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	_, err = GetResponse(context.Background(), client, srv.URL, nil)
+	statusErr := &StatusError{}
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+	assert.Equal(t, "0", statusErr.Header.Get("X-RateLimit-Remaining"))
+	assert.EqualError(t, err, "expected http 200, but 403")
+}
+
+func TestBytesCounter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer srv.Close()
+
+	client, err := New(DefaultConfig())
+	assert.NoError(t, err)
+
+	n := atomic.Int64{}
+	ctx := WithBytesCounter(context.Background(), &n)
+
+	for range 2 {
+		body, err := Get(ctx, client, srv.URL)
+		assert.NoError(t, err)
+		_, err = io.Copy(io.Discard, body)
+		assert.NoError(t, err)
+		body.Close()
+	}
+	assert.Equal(t, int64(20), n.Load())
+
+	// Requests without the counter are not counted
+	body, err := Get(context.Background(), client, srv.URL)
+	assert.NoError(t, err)
+	_, _ = io.Copy(io.Discard, body)
+	body.Close()
+	assert.Equal(t, int64(20), n.Load())
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	attempts := atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	client, err := New(Config{Retries: 2})
+	assert.NoError(t, err)
+
+	// 404 is not retried
+	_, err = Get(context.Background(), client, srv.URL)
+	assert.EqualError(t, err, "expected http 200, but 404")
+	assert.Equal(t, int32(1), attempts.Load())
+}
+
+func TestReadTimeout(t *testing.T) {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("start"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	client, err := New(Config{ReadTimeout: 50 * time.Millisecond})
+	assert.NoError(t, err)
+
+	body, err := Get(context.Background(), client, srv.URL)
+	assert.NoError(t, err)
+	defer body.Close()
+
+	_, err = io.ReadAll(body)
+	assert.True(t, errors.Is(err, ErrReadTimeout), "got %v", err)
+}
+
+func TestContextCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client, err := New(Config{Retries: 100})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = Get(ctx, client, srv.URL)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+}
+
+func TestInvalidCAFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(path, []byte("not a certificate"), 0644))
+
+	_, err := New(Config{CAFiles: []string{path}})
+	assert.Error(t, err)
+}
+
+func TestContentVersion(t *testing.T) {
+	gets := map[string]int{}
+
+	const etag = `"v1"`
+	const modified = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte("page"))
+	})
+	mux.HandleFunc("/modified", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") == modified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", modified)
+		w.Write([]byte("page"))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page"))
+	})
+	srv := httptest.NewServer(countGets(mux, gets))
+	defer srv.Close()
+
+	tests := []struct {
+		path    string
+		version string
+	}{
+		{"/etag", `etag:"v1"`},
+		{"/modified", "last-modified:" + modified},
+		{"/plain", "sha256:3660315a9af3df255d8f19ab077e4797822b41488a0e2a04bc6af71213c23274"},
+	}
+	for _, test := range tests {
+		version, err := ContentVersion(context.Background(), srv.Client(), srv.URL+test.path, "")
+		assert.NoError(t, err)
+		assert.Equal(t, test.version, version)
+
+		// The server responds with 304 and the version stays the same
+		same, err := ContentVersion(context.Background(), srv.Client(), srv.URL+test.path, version)
+		assert.NoError(t, err)
+		assert.Equal(t, version, same, "path: %s", test.path)
+	}
+
+	// The headers come from HEAD requests, only the hash needs the body
+	assert.Equal(t, map[string]int{"/plain": 2}, gets)
+
+	t.Run("cache", func(t *testing.T) {
+		clear(gets)
+		cache := &ContentCache{}
+		ctx := context.Background()
+
+		version, err := cache.Version(ctx, srv.Client(), srv.URL+"/plain", "")
+		assert.NoError(t, err)
+
+		downloaded := atomic.Int64{}
+		body, err := cache.Get(WithBytesCounter(ctx, &downloaded), srv.Client(), srv.URL+"/plain", version)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(body)
+		assert.NoError(t, err)
+		assert.Equal(t, "page", string(data))
+		assert.Equal(t, int64(4), downloaded.Load())
+		assert.Equal(t, map[string]int{"/plain": 1}, gets)
+
+		// Kept only for a single download
+		body, err = cache.Get(ctx, srv.Client(), srv.URL+"/plain", version)
+		assert.NoError(t, err)
+		body.Close()
+		assert.Equal(t, map[string]int{"/plain": 2}, gets)
+	})
+}
+
+// countGets counts the GET requests by path
+func countGets(next http.Handler, gets map[string]int) http.Handler {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			gets[r.URL.Path]++
+			mu.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,237 @@
+// Package query implements a small query language for filtering
+// packages and options by their fields, e.g.
+//
+//	type:bool name:services.nginx.* license:mit platform:aarch64-darwin !broken
+//
+// Every term of the query must match for a package to match:
+//
+//   - `field:value` matches if any value of the field contains the value.
+//     If the value has `*` or `?` in it, the whole field value must match
+//     it as a glob pattern instead
+//   - `field` matches if the field is set and is not false
+//   - `!` in front of a term negates it
+//
+// Fields are the JSON names of the fields of the package structs, like
+// `description` or `type`, and can be either dotted paths, like `meta.broken`,
+// or just the last segment, like `broken`. Fields of nested structs, such as
+// `license`, include the values of all their fields. Matching is case-insensitive
+package query
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Aliases maps user-friendly field names to
+// the names used by the package structs
+var Aliases = map[string]string{
+	"name":     "_key",
+	"platform": "platforms",
+	"alias":    "aliases",
+}
+
+var ErrEmptyField = errors.New("empty field name")
+
+type Query struct {
+	terms []term
+}
+
+type term struct {
+	field   string
+	pattern string
+	// hasValue is false for terms like `broken`
+	hasValue bool
+	negate   bool
+}
+
+func Parse(query string) (Query, error) {
+	q := Query{}
+
+	for _, word := range strings.Fields(query) {
+		t := term{}
+
+		word, t.negate = strings.CutPrefix(word, "!")
+		t.field, t.pattern, t.hasValue = strings.Cut(word, ":")
+		t.field = strings.ToLower(t.field)
+		t.pattern = strings.ToLower(t.pattern)
+
+		if t.field == "" {
+			return Query{}, fmt.Errorf("%w: %q", ErrEmptyField, word)
+		}
+		if alias, ok := Aliases[t.field]; ok {
+			t.field = alias
+		}
+
+		q.terms = append(q.terms, t)
+	}
+
+	return q, nil
+}
+
+func (q Query) Empty() bool {
+	return len(q.terms) == 0
+}
+
+// Match reports whether the package matches the query. The package
+// is usually a pointer to one of the package structs
+func (q Query) Match(pkg any) bool {
+	if q.Empty() {
+		return true
+	}
+
+	fields := Fields(pkg)
+	for _, t := range q.terms {
+		if t.match(fields[t.field]) == t.negate {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t term) match(values []string) bool {
+	if !t.hasValue {
+		for _, v := range values {
+			if v != "" && v != "false" && v != "0" {
+				return true
+			}
+		}
+		return false
+	}
+
+	isGlob := strings.ContainsAny(t.pattern, "*?")
+	for _, v := range values {
+		v = strings.ToLower(v)
+		if isGlob && matchGlob(t.pattern, v) {
+			return true
+		}
+		if !isGlob && strings.Contains(v, t.pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fields returns the values of all the fields of the package by
+// their lowercase names. See the package doc for the naming
+func Fields(pkg any) map[string][]string {
+	fields := map[string][]string{}
+	collect(fields, reflect.ValueOf(pkg), nil)
+	return fields
+}
+
+// collect walks the value and adds every scalar it finds to
+// every field on the path to it
+func collect(fields map[string][]string, v reflect.Value, path []string) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			collect(fields, v.Elem(), path)
+		}
+
+	case reflect.Struct:
+		typ := v.Type()
+		for i := range typ.NumField() {
+			field := typ.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			// Embedded structs without a name are flattened by
+			// encoding/json, so flatten them here too
+			if name == "" && field.Anonymous {
+				collect(fields, v.Field(i), path)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			collect(fields, v.Field(i), append(path, strings.ToLower(name)))
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			collect(fields, v.Index(i), path)
+		}
+
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			collect(fields, iter.Value(), path)
+		}
+
+	default:
+		s, ok := scalar(v)
+		if !ok || len(path) == 0 {
+			return
+		}
+
+		// A value of `meta.license.spdxId` belongs to `spdxid`,
+		// `license`, `license.spdxid`, `meta`, `meta.license` and
+		// `meta.license.spdxid`
+		for i := range path {
+			for j := i + 1; j <= len(path); j++ {
+				name := strings.Join(path[i:j], ".")
+				fields[name] = append(fields[name], s)
+			}
+		}
+	}
+}
+
+func scalar(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), true
+	}
+
+	return "", false
+}
+
+// matchGlob matches the whole text against the pattern where `*`
+// matches any sequence of characters and `?` any single one.
+// Unlike path.Match, `*` also matches separators
+func matchGlob(pattern, text string) bool {
+	p, t := []rune(pattern), []rune(text)
+
+	// Position to backtrack to after the last `*`
+	star, starText := -1, 0
+
+	pi, ti := 0, 0
+	for ti < len(t) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == t[ti]):
+			pi++
+			ti++
+		case pi < len(p) && p[pi] == '*':
+			star, starText = pi, ti
+			pi++
+		case star >= 0:
+			starText++
+			pi, ti = star+1, starText
+		default:
+			return false
+		}
+	}
+
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+
+	return pi == len(p)
+}
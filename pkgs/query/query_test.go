@@ -0,0 +1,92 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+type testPkg struct {
+	embedded
+	Type string   `json:"type"`
+	Meta testMeta `json:"meta"`
+}
+
+type embedded struct {
+	Name string `json:"_key"`
+}
+
+type testMeta struct {
+	Broken    bool           `json:"broken"`
+	Licenses  []testLicense  `json:"license"`
+	Platforms []string       `json:"platforms"`
+	Position  *string        `json:"position"`
+	Skipped   string         `json:"-"`
+	Extra     map[string]int `json:"extra"`
+}
+
+type testLicense struct {
+	SpdxID   string `json:"spdxId"`
+	FullName string `json:"fullName"`
+}
+
+func TestMatch(t *testing.T) {
+	pkg := &testPkg{
+		embedded: embedded{Name: "services.nginx.enable"},
+		Type:     "boolean",
+		Meta: testMeta{
+			Licenses:  []testLicense{{SpdxID: "MIT", FullName: "MIT License"}},
+			Platforms: []string{"x86_64-linux", "aarch64-darwin"},
+			Skipped:   "skipped",
+			Extra:     map[string]int{"a": 42},
+		},
+	}
+
+	tests := []struct {
+		query string
+		match bool
+	}{
+		{"", true},
+		{"type:bool name:services.nginx.* license:mit platform:aarch64-darwin !broken", true},
+		{"type:bool*", true},
+		{"type:bool?", false},
+		{"name:services.nginx", true},
+		{"name:*.nginx", false},
+		{"name:*.enable", true},
+		{"TYPE:BOOLEAN", true},
+		{"broken", false},
+		{"meta.broken:false", true},
+		{"license.spdxid:mit", true},
+		{"spdxid:gpl", false},
+		{"!license:gpl", true},
+		{"license:gpl", false},
+		{"platform:riscv", false},
+		{"position", false},
+		{"skipped", false},
+		{"extra:42", true},
+		{"unknown:value", false},
+		{"!unknown", true},
+	}
+	for _, test := range tests {
+		q, err := Parse(test.query)
+		assert.NoError(t, err)
+		assert.Equal(t, test.match, q.Match(pkg), "query: %q", test.query)
+	}
+}
+
+func TestParseEmptyField(t *testing.T) {
+	_, err := Parse("type:bool :value")
+	assert.IsError(t, err, ErrEmptyField)
+
+	_, err = Parse("!")
+	assert.IsError(t, err, ErrEmptyField)
+}
+
+func TestMatchGlob(t *testing.T) {
+	assert.True(t, matchGlob("*", ""))
+	assert.True(t, matchGlob("a*c", "abbbc"))
+	assert.True(t, matchGlob("a*b*c", "a/b/c"))
+	assert.True(t, matchGlob("?b", "ab"))
+	assert.False(t, matchGlob("a*c", "abcd"))
+	assert.False(t, matchGlob("a?", "a"))
+}
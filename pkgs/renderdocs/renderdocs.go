@@ -1,11 +1,15 @@
 package renderdocs
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/antchfx/htmlquery"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 type Package struct {
@@ -18,44 +22,147 @@ type Package struct {
 	DeclaredBy []string
 }
 
-// Parse parses HTML generated by nixos-render-docs
-func Parse(doc *html.Node) (map[string]Package, error) {
-	// Some options might have nested "dl"s with class=variablelist,
-	// so it's important to find the first one and perform
-	// subsequent searches from it
-	dl := htmlquery.FindOne(doc, `//dl[@class="variablelist"]`)
-	nameNodes := htmlquery.Find(dl, `/dt`)
-	contentNodes := htmlquery.Find(dl, `/dd`)
-
-	if len(nameNodes) != len(contentNodes) {
-		return nil, fmt.Errorf("len(%d) != len(%d)", len(nameNodes), len(contentNodes))
+// WalkReader calls fn for every package of the HTML generated by
+// nixos-render-docs in the order they appear in it, stopping on the
+// first error. It never has the whole document in memory, only the
+// package it's parsing
+func WalkReader(r io.Reader, fn func(pkg Package) error) error {
+	z := html.NewTokenizer(r)
+
+	inList := false
+	// How deep inside the nested lists of an option we are.
+	// Only the terms and definitions of the top list are options
+	nested := 0
+	entry := []byte{}
+	var term *html.Node
+
+	flush := func() error {
+		node, err := parseEntry(entry)
+		entry = entry[:0]
+		if err != nil || node == nil {
+			return err
+		}
+
+		if node.Data == "dt" {
+			if term != nil {
+				return errors.New("term without definition")
+			}
+			term = node
+			return nil
+		}
+
+		if term == nil {
+			return errors.New("definition without term")
+		}
+		pkg := newPackage(term, node)
+		term = nil
+
+		return fn(pkg)
+	}
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return z.Err()
+			}
+			if !inList {
+				return errors.New("no options list found")
+			}
+			return errors.New("options list is not closed")
+		}
+
+		tag := []byte{}
+		if tt == html.StartTagToken || tt == html.EndTagToken {
+			tag, _ = z.TagName()
+		}
+
+		if !inList {
+			inList = tt == html.StartTagToken && string(tag) == "dl" && isOptionsList(z)
+			continue
+		}
+
+		switch {
+		case tt == html.StartTagToken && string(tag) == "dl":
+			nested++
+
+		case tt == html.EndTagToken && string(tag) == "dl":
+			if nested == 0 {
+				if err := flush(); err != nil {
+					return err
+				}
+				if term != nil {
+					return errors.New("term without definition")
+				}
+				return nil
+			}
+			nested--
+
+		case tt == html.StartTagToken && nested == 0 && (string(tag) == "dt" || string(tag) == "dd"):
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		entry = append(entry, z.Raw()...)
 	}
+}
 
-	pkgs := map[string]Package{}
-	for i, nameNode := range nameNodes {
-		pkg := Package{}
+// isOptionsList reports whether the dl the tokenizer is at is the list of options
+func isOptionsList(z *html.Tokenizer) bool {
+	for {
+		key, val, more := z.TagAttr()
+		if string(key) == "class" && string(val) == "variablelist" {
+			return true
+		}
+		if !more {
+			return false
+		}
+	}
+}
 
-		span := htmlquery.FindOne(nameNode, `/span[@class="term"]`)
-		pkgName := htmlquery.InnerText(span)
-		pkgName = strings.TrimSpace(pkgName)
-		pkg.Name = pkgName
+var dlContext = &html.Node{
+	Type:     html.ElementNode,
+	Data:     "dl",
+	DataAtom: atom.Dl,
+}
 
-		contentNode := contentNodes[i]
-		props := extractProperties(contentNode)
+// parseEntry parses the HTML of a single dt or dd. Anything in between
+// them, like whitespace, is not an element, and then the node is nil
+func parseEntry(entry []byte) (*html.Node, error) {
+	nodes, err := html.ParseFragment(bytes.NewReader(entry), dlContext)
+	if err != nil {
+		return nil, err
+	}
 
-		pkg.Type = NormProp(props.Type)
-		pkg.Default = NormProp(props.Default)
-		pkg.Example = NormProp(props.Example)
-		for _, decl := range props.DeclaredBy {
-			pkg.DeclaredBy = append(pkg.DeclaredBy, NormProp(decl))
+	for _, node := range nodes {
+		if node.Type == html.ElementNode {
+			return node, nil
 		}
+	}
+	return nil, nil
+}
+
+func newPackage(nameNode, contentNode *html.Node) Package {
+	pkg := Package{}
 
-		pkg.Description = htmlquery.OutputHTML(contentNode, true)
+	span := htmlquery.FindOne(nameNode, `/span[@class="term"]`)
+	pkgName := htmlquery.InnerText(span)
+	pkgName = strings.TrimSpace(pkgName)
+	pkg.Name = pkgName
 
-		pkgs[pkgName] = pkg
+	props := extractProperties(contentNode)
+
+	pkg.Type = NormProp(props.Type)
+	pkg.Default = NormProp(props.Default)
+	pkg.Example = NormProp(props.Example)
+	for _, decl := range props.DeclaredBy {
+		pkg.DeclaredBy = append(pkg.DeclaredBy, NormProp(decl))
 	}
 
-	return pkgs, nil
+	pkg.Description = htmlquery.OutputHTML(contentNode, true)
+
+	return pkg
 }
 
 func NormProp(prop string) string {
@@ -0,0 +1,68 @@
+package renderdocs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestWalkReader(t *testing.T) {
+	page := `<html><body>
+<dl class="other"><dt><span class="term">not an option</span></dt><dd>x</dd></dl>
+<dl class="variablelist">
+  <dt><span class="term"><code>a.enable</code></span></dt>
+  <dd>
+    <p>Enable it.</p>
+    <dl class="variablelist"><dt>nested</dt><dd>list</dd></dl>
+    <p><span class="emphasis"><em>Type:</em></span> boolean</p>
+  </dd>
+  <dt><span class="term"><code>b</code></span></dt>
+  <dd><p>B&amp;B</p></dd>
+</dl>
+</body></html>`
+
+	pkgs := []Package{}
+	err := WalkReader(strings.NewReader(page), func(pkg Package) error {
+		pkgs = append(pkgs, pkg)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(pkgs))
+	assert.Equal(t, "a.enable", pkgs[0].Name)
+	assert.Equal(t, "boolean", pkgs[0].Type)
+	assert.Contains(t, pkgs[0].Description, "nested")
+	assert.Equal(t, "b", pkgs[1].Name)
+	assert.Contains(t, pkgs[1].Description, "B&amp;B")
+
+	err = WalkReader(strings.NewReader(`<html></html>`), func(Package) error { return nil })
+	assert.EqualError(t, err, "no options list found")
+}
+
+func TestWalkReaderManual(t *testing.T) {
+	file, err := os.Open("../../indexes/darwin/testdata/index.html")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	pkgs := map[string]Package{}
+	count := 0
+	err = WalkReader(file, func(pkg Package) error {
+		pkgs[pkg.Name] = pkg
+		count++
+		return nil
+	})
+	assert.NoError(t, err)
+	// The nested lists are not options
+	assert.Equal(t, 1037, count)
+	assert.Equal(t, count, len(pkgs))
+
+	pkg := pkgs["environment.systemPackages"]
+	assert.Equal(t, "list of package", pkg.Type)
+	assert.Equal(t, "[ ]", pkg.Default)
+	assert.Equal(t, "[ pkgs.curl pkgs.vim ]", pkg.Example)
+	assert.Equal(t, []string{
+		"https://github.com/LnL7/nix-darwin/blob/6ab392f626a19f1122d1955c401286e1b7cf6b53/modules/environment",
+	}, pkg.DeclaredBy)
+	assert.Contains(t, pkg.Description, "/run/current-system/sw")
+}
@@ -0,0 +1,127 @@
+// Package s3 is a minimal anonymous client for listing objects of public
+// S3 buckets. It only implements ListObjectsV2, which is all the nixpkgs
+// and nixos fetchers need to find the latest release in the nix-releases bucket
+package s3
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	// BucketURL is where the bucket is served from. Either virtual-hosted,
+	// e.g. https://nix-releases.s3.eu-west-1.amazonaws.com, or path-style,
+	// e.g. http://localhost:9000/nix-releases for S3-compatible stand-ins
+	BucketURL string
+
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+type ListInput struct {
+	Prefix     string
+	Delimiter  string
+	StartAfter string
+}
+
+type Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+}
+
+type listResult struct {
+	Contents              []Object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+// Error is an error response of S3
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: http %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: http %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// ListObjects lists all the objects matching the input, going through
+// all the pages. With a delimiter, the common prefixes are not returned
+func (c *Client) ListObjects(ctx context.Context, in ListInput) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		page, err := c.listPage(ctx, in, token)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Contents...)
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (c *Client) listPage(ctx context.Context, in ListInput, token string) (listResult, error) {
+	params := url.Values{}
+	params.Set("list-type", "2")
+	if in.Prefix != "" {
+		params.Set("prefix", in.Prefix)
+	}
+	if in.Delimiter != "" {
+		params.Set("delimiter", in.Delimiter)
+	}
+	if in.StartAfter != "" {
+		params.Set("start-after", in.StartAfter)
+	}
+	if token != "" {
+		params.Set("continuation-token", token)
+	}
+
+	reqURL := strings.TrimSuffix(c.BucketURL, "/") + "/?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return listResult{}, fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := cmp.Or(c.HTTPClient, http.DefaultClient).Do(req)
+	if err != nil {
+		return listResult{}, fmt.Errorf("list objects: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return listResult{}, fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		s3err := &Error{StatusCode: resp.StatusCode}
+		// The body is not always an XML, e.g. when it's
+		// a proxy responding, so just ignore the error
+		_ = xml.Unmarshal(body, s3err)
+		return listResult{}, s3err
+	}
+
+	page := listResult{}
+	if err := xml.Unmarshal(body, &page); err != nil {
+		return listResult{}, fmt.Errorf("decode response: %w", err)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,70 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+const page = `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Name>nix-releases</Name>
+  <Contents><Key>%s</Key><Size>%d</Size></Contents>
+  <CommonPrefixes><Prefix>nixpkgs/dir/</Prefix></CommonPrefixes>
+  <IsTruncated>%t</IsTruncated>
+  <NextContinuationToken>%s</NextContinuationToken>
+</ListBucketResult>`
+
+func TestListObjects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		assert.Equal(t, "/nix-releases/", r.URL.Path)
+		assert.Equal(t, "2", q.Get("list-type"))
+		assert.Equal(t, "nixpkgs/", q.Get("prefix"))
+		assert.Equal(t, "/", q.Get("delimiter"))
+		assert.Equal(t, "nixpkgs/a", q.Get("start-after"))
+
+		switch q.Get("continuation-token") {
+		case "":
+			fmt.Fprintf(w, page, "nixpkgs/b", 1, true, "next")
+		case "next":
+			fmt.Fprintf(w, page, "nixpkgs/c", 2, false, "")
+		default:
+			t.Fatalf("unexpected token %q", q.Get("continuation-token"))
+		}
+	}))
+	defer srv.Close()
+
+	client := &Client{BucketURL: srv.URL + "/nix-releases/"}
+	objects, err := client.ListObjects(context.Background(), ListInput{
+		Prefix:     "nixpkgs/",
+		Delimiter:  "/",
+		StartAfter: "nixpkgs/a",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Object{
+		{Key: "nixpkgs/b", Size: 1},
+		{Key: "nixpkgs/c", Size: 2},
+	}, objects)
+}
+
+func TestListObjectsError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+	}))
+	defer srv.Close()
+
+	client := &Client{BucketURL: srv.URL}
+	_, err := client.ListObjects(context.Background(), ListInput{})
+
+	s3err := &Error{}
+	assert.True(t, errors.As(err, &s3err))
+	assert.Equal(t, http.StatusForbidden, s3err.StatusCode)
+	assert.Equal(t, "AccessDenied", s3err.Code)
+}